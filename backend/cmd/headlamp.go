@@ -54,7 +54,6 @@ import (
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/plugins"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/portforward"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/telemetry"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
@@ -80,6 +79,9 @@ type HeadlampConfig struct {
 	telemetryConfig           cfg.Config
 	oidcScopes                []string
 	telemetryHandler          *telemetry.RequestHandler
+	// shutdown, once closed, gracefully shuts the server down, returning
+	// from StartHeadlampServer once its requests are done.
+	shutdown <-chan struct{}
 }
 
 const DrainNodeCacheTTL = 20 // seconds
@@ -92,6 +94,10 @@ const ContextUpdateCacheTTL = 20 * time.Second // seconds
 
 const JWTExpirationTTL = 10 * time.Second // seconds
 
+// serverShutdownTimeout bounds waiting for the requests being served when
+// shutting the server down.
+const serverShutdownTimeout = 5 * time.Second
+
 const kubeConfigSource = "kubeconfig" // source for kubeconfig contexts
 
 const (
@@ -466,7 +472,8 @@ func createHeadlampHandler(config *HeadlampConfig) http.Handler {
 	// to enable this endpoint, run command run-backend-with-metrics
 	// or set the environment variable HEADLAMP_CONFIG_METRICS_ENABLED=true
 	if config.Metrics != nil && config.telemetryConfig.MetricsEnabled != nil && *config.telemetryConfig.MetricsEnabled {
-		r.Handle("/metrics", promhttp.Handler())
+		exemplars := config.telemetryConfig.MetricsExemplars != nil && *config.telemetryConfig.MetricsExemplars
+		r.Handle("/metrics", telemetry.MetricsHandler(exemplars))
 		logger.Log(logger.LevelInfo, nil, nil, "prometheus metrics endpoint: /metrics")
 	}
 
@@ -680,16 +687,88 @@ func createHeadlampHandler(config *HeadlampConfig) http.Handler {
 		portforward.StopOrDeletePortForward(config.cache, w, r)
 	}).Methods("DELETE")
 
+	r.HandleFunc("/portforward/all", func(w http.ResponseWriter, r *http.Request) {
+		portforward.StopAllPortForwards(config.cache, w, r)
+	}).Methods("DELETE")
+
+	r.HandleFunc("/portforward/pause", func(w http.ResponseWriter, r *http.Request) {
+		portforward.PausePortForward(config.cache, w, r)
+	}).Methods("POST")
+
+	r.HandleFunc("/portforward/resume", func(w http.ResponseWriter, r *http.Request) {
+		portforward.ResumePortForward(config.KubeConfigStore, config.cache, w, r)
+	}).Methods("POST")
+
+	r.HandleFunc("/portforward/rebind", func(w http.ResponseWriter, r *http.Request) {
+		portforward.RebindPortForward(config.cache, w, r)
+	}).Methods("POST")
+
+	r.HandleFunc("/portforward/batch", func(w http.ResponseWriter, r *http.Request) {
+		portforward.StartPortForwards(config.KubeConfigStore, config.cache, w, r)
+	}).Methods("POST")
+
+	r.HandleFunc("/portforward/bulk", func(w http.ResponseWriter, r *http.Request) {
+		portforward.StartPortForwardsBulk(config.KubeConfigStore, config.cache, w, r)
+	}).Methods("POST")
+
+	r.HandleFunc("/portforward/batch/check", func(w http.ResponseWriter, r *http.Request) {
+		portforward.CheckPortForwards(config.KubeConfigStore, w, r)
+	}).Methods("POST")
+
+	r.HandleFunc("/portforward/permission", func(w http.ResponseWriter, r *http.Request) {
+		portforward.CheckPortForwardPermission(config.KubeConfigStore, w, r)
+	}).Methods("GET")
+
+	r.HandleFunc("/portforward/summary", func(w http.ResponseWriter, r *http.Request) {
+		portforward.GetPortForwardSummary(config.cache, w, r)
+	}).Methods("GET")
+
 	r.HandleFunc("/portforward/list", func(w http.ResponseWriter, r *http.Request) {
 		portforward.GetPortForwards(config.cache, w, r)
 	})
 
+	r.HandleFunc("/portforward/user", func(w http.ResponseWriter, r *http.Request) {
+		portforward.GetUserPortForwards(config.cache, w, r)
+	}).Methods("GET")
+
+	r.HandleFunc("/portforward/export", func(w http.ResponseWriter, r *http.Request) {
+		portforward.ExportPortForwards(config.cache, w, r)
+	}).Methods("GET")
+
+	r.HandleFunc("/portforward/events", func(w http.ResponseWriter, r *http.Request) {
+		portforward.GetPortForwardEvents(config.cache, w, r)
+	}).Methods("GET")
+
+	r.HandleFunc("/portforward/targets", func(w http.ResponseWriter, r *http.Request) {
+		portforward.GetPortForwardTargets(config.cache, w, r)
+	}).Methods("GET")
+
+	r.HandleFunc("/portforward/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		portforward.ReconcilePortForwards(config.KubeConfigStore, config.cache, w, r)
+	}).Methods("POST")
+
+	r.HandleFunc("/portforward/monitor", func(w http.ResponseWriter, r *http.Request) {
+		portforward.SetPortForwardMonitor(config.cache, w, r)
+	}).Methods("PUT")
+
+	r.HandleFunc("/portforward/rename", func(w http.ResponseWriter, r *http.Request) {
+		portforward.RenamePortForward(config.cache, w, r)
+	}).Methods("PUT")
+
+	r.HandleFunc("/portforward/store", func(w http.ResponseWriter, r *http.Request) {
+		portforward.GetStateStoreStatus(config.cache, w, r)
+	}).Methods("GET")
+
+	r.HandleFunc("/portforward/websocket", func(w http.ResponseWriter, r *http.Request) {
+		portforward.PortForwardWebSocket(config.cache, w, r)
+	}).Methods("GET")
+
 	r.HandleFunc("/drain-node", config.handleNodeDrain).Methods("POST")
 	r.HandleFunc("/drain-node-status",
 		config.handleNodeDrainStatus).Methods("GET").Queries("cluster", "{cluster}", "nodeName", "{node}")
 	r.HandleFunc("/portforward", func(w http.ResponseWriter, r *http.Request) {
 		portforward.GetPortForwardByID(config.cache, w, r)
-	}).Methods("GET")
+	}).Methods("GET", "HEAD")
 
 	r.HandleFunc("/oidc-callback", func(w http.ResponseWriter, r *http.Request) {
 		state := r.URL.Query().Get("state")
@@ -1169,8 +1248,33 @@ func StartHeadlampServer(config *HeadlampConfig) {
 
 	addr := fmt.Sprintf("%s:%d", config.ListenAddr, config.Port)
 
+	server := &http.Server{Addr: addr, Handler: handler} //nolint:gosec
+	shutdownDone := make(chan struct{})
+
+	if config.shutdown != nil {
+		go func() {
+			defer close(shutdownDone)
+
+			<-config.shutdown
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+			defer cancel()
+
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				logger.Log(logger.LevelError, nil, err, "Failed to shutdown server")
+			}
+		}()
+	}
+
 	// Start server
-	if err := http.ListenAndServe(addr, handler); err != nil { //nolint:gosec
+	if err := server.ListenAndServe(); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			// The requests being served are done once Shutdown returns.
+			<-shutdownDone
+
+			return
+		}
+
 		logger.Log(logger.LevelError, nil, err, "Failed to start server")
 
 		HandleServerStartError(&err)
@@ -17,8 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/config"
@@ -26,6 +30,7 @@ import (
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/kubeconfig"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/plugins"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/portforward"
 )
 
 func main() {
@@ -41,7 +46,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	portforward.DeniedNamespaces = config.ParseNamespaces(conf.PortForwardDeniedNamespaces)
+
+	if conf.PortForwardStoreUnavailablePolicy != "" {
+		portforward.StoreUnavailablePolicy = conf.PortForwardStoreUnavailablePolicy
+	}
+
+	if conf.PortForwardHostNetworkPolicy != "" {
+		portforward.HostNetworkPolicy = conf.PortForwardHostNetworkPolicy
+	}
+
+	portforward.MaxPortForwardsPerCluster = conf.PortForwardMaxPerCluster
+	portforward.MaxStartRetries = conf.PortForwardMaxStartRetries
+	portforward.StoppedTTL = time.Duration(conf.PortForwardStoppedTTLSeconds) * time.Second
+	portforward.AllowNonLoopbackBind = conf.PortForwardAllowNonLoopbackBind
+	portforward.PermissionCheckTimeout = time.Duration(conf.PortForwardPermissionCheckTimeoutSeconds) * time.Second
+	portforward.DialTimeout = time.Duration(conf.PortForwardDialTimeoutSeconds) * time.Second
+
+	// The range was validated when parsing the config.
+	portforward.PortRangeMin, portforward.PortRangeMax, _ = config.ParsePortRange(conf.PortForwardPortRange)
+
 	cache := cache.New[interface{}]()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdown := make(chan struct{})
+
+	go portforward.ReapStoppedPortForwards(ctx, cache)
+	go shutdownPortForwardsOnSignal(ctx, cache, shutdown)
+
 	kubeConfigStore := kubeconfig.NewContextStore()
 	multiplexer := NewMultiplexer(kubeConfigStore)
 
@@ -72,11 +106,13 @@ func main() {
 		oidcUseAccessToken:        conf.OidcUseAccessToken,
 		cache:                     cache,
 		multiplexer:               multiplexer,
+		shutdown:                  shutdown,
 		telemetryConfig: config.Config{
 			ServiceName:        conf.ServiceName,
 			ServiceVersion:     conf.ServiceVersion,
 			TracingEnabled:     conf.TracingEnabled,
 			MetricsEnabled:     conf.MetricsEnabled,
+			MetricsExemplars:   conf.MetricsExemplars,
 			JaegerEndpoint:     conf.JaegerEndpoint,
 			OTLPEndpoint:       conf.OTLPEndpoint,
 			UseOTLPHTTP:        conf.UseOTLPHTTP,
@@ -86,6 +122,26 @@ func main() {
 	})
 }
 
+// portForwardShutdownTimeout bounds stopping the port forwards when terminated.
+const portForwardShutdownTimeout = 5 * time.Second
+
+// shutdownPortForwardsOnSignal waits for ctx to be done, once the process is
+// interrupted or terminated, then stops the port forwards, closing their
+// connections to the API servers, and closes shutdown for the server to shut
+// down in turn and main to return.
+func shutdownPortForwardsOnSignal(ctx context.Context, cache cache.Cache[interface{}], shutdown chan struct{}) {
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), portForwardShutdownTimeout)
+	defer cancel()
+
+	if err := portforward.Shutdown(shutdownCtx, cache); err != nil {
+		logger.Log(logger.LevelError, nil, err, "shutting down portforwards")
+	}
+
+	close(shutdown)
+}
+
 func runListPlugins() {
 	conf, err := config.Parse(os.Args[2:])
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/knadh/koanf"
@@ -19,6 +20,26 @@ import (
 
 const defaultPort = 4466
 
+// defaultPortForwardMaxPerCluster is the default maximum number of port
+// forwards running at once in a cluster.
+const defaultPortForwardMaxPerCluster = 100
+
+// defaultPortForwardMaxStartRetries is the default number of retries of
+// starting a port forward.
+const defaultPortForwardMaxStartRetries = 2
+
+// defaultPortForwardStoppedTTLSeconds is the default time stopped port
+// forwards are kept for.
+const defaultPortForwardStoppedTTLSeconds = 3600
+
+// defaultPortForwardPermissionCheckTimeoutSeconds is the default time the
+// permission check of a port forward can take.
+const defaultPortForwardPermissionCheckTimeoutSeconds = 5
+
+// defaultPortForwardDialTimeoutSeconds is the default time opening the
+// connection of a port forward to the API server can take.
+const defaultPortForwardDialTimeoutSeconds = 10
+
 type Config struct {
 	InCluster                 bool   `koanf:"in-cluster"`
 	DevMode                   bool   `koanf:"dev"`
@@ -41,11 +62,23 @@ type Config struct {
 	OidcValidatorIdpIssuerURL string `koanf:"oidc-validator-idp-issuer-url"`
 	OidcScopes                string `koanf:"oidc-scopes"`
 	OidcUseAccessToken        bool   `koanf:"oidc-use-access-token"`
+	// portforward configs
+	PortForwardDeniedNamespaces              string `koanf:"portforward-denied-namespaces"`
+	PortForwardStoreUnavailablePolicy        string `koanf:"portforward-store-unavailable-policy"`
+	PortForwardPortRange                     string `koanf:"portforward-port-range"`
+	PortForwardMaxPerCluster                 int    `koanf:"portforward-max-per-cluster"`
+	PortForwardMaxStartRetries               int    `koanf:"portforward-max-start-retries"`
+	PortForwardStoppedTTLSeconds             int    `koanf:"portforward-stopped-ttl-seconds"`
+	PortForwardAllowNonLoopbackBind          bool   `koanf:"portforward-allow-non-loopback-bind"`
+	PortForwardPermissionCheckTimeoutSeconds int    `koanf:"portforward-permission-check-timeout-seconds"`
+	PortForwardHostNetworkPolicy             string `koanf:"portforward-host-network-policy"`
+	PortForwardDialTimeoutSeconds            int    `koanf:"portforward-dial-timeout-seconds"`
 	// telemetry configs
 	ServiceName        string   `koanf:"service-name"`
 	ServiceVersion     *string  `koanf:"service-version"`
 	TracingEnabled     *bool    `koanf:"tracing-enabled"`
 	MetricsEnabled     *bool    `koanf:"metrics-enabled"`
+	MetricsExemplars   *bool    `koanf:"metrics-exemplars"`
 	JaegerEndpoint     *string  `koanf:"jaeger-endpoint"`
 	OTLPEndpoint       *string  `koanf:"otlp-endpoint"`
 	UseOTLPHTTP        *bool    `koanf:"use-otlp-http"`
@@ -81,9 +114,90 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.PortForwardStoreUnavailablePolicy != "" && c.PortForwardStoreUnavailablePolicy != "fail" &&
+		c.PortForwardStoreUnavailablePolicy != "fallback" {
+		return errors.New("portforward-store-unavailable-policy must be fail or fallback")
+	}
+
+	if _, _, err := ParsePortRange(c.PortForwardPortRange); err != nil {
+		return err
+	}
+
+	if c.PortForwardMaxPerCluster < 0 {
+		return errors.New("portforward-max-per-cluster must not be negative")
+	}
+
+	if c.PortForwardMaxStartRetries < 0 {
+		return errors.New("portforward-max-start-retries must not be negative")
+	}
+
+	if c.PortForwardStoppedTTLSeconds < 0 {
+		return errors.New("portforward-stopped-ttl-seconds must not be negative")
+	}
+
+	if c.PortForwardPermissionCheckTimeoutSeconds < 1 {
+		return errors.New("portforward-permission-check-timeout-seconds must be at least 1")
+	}
+
+	if c.PortForwardHostNetworkPolicy != "" && c.PortForwardHostNetworkPolicy != "warn" &&
+		c.PortForwardHostNetworkPolicy != "reject" {
+		return errors.New("portforward-host-network-policy must be warn or reject")
+	}
+
+	if c.PortForwardDialTimeoutSeconds < 1 {
+		return errors.New("portforward-dial-timeout-seconds must be at least 1")
+	}
+
 	return nil
 }
 
+// ParsePortRange parses a port range of the form min-max, e.g. 30000-30100.
+// An empty range is returned as 0-0.
+func ParsePortRange(portRange string) (int, int, error) {
+	if portRange == "" {
+		return 0, 0, nil
+	}
+
+	invalid := fmt.Errorf("portforward-port-range %q must be of the form min-max, with 1 <= min <= max <= 65535",
+		portRange)
+
+	minPort, maxPort, ok := strings.Cut(portRange, "-")
+	if !ok {
+		return 0, 0, invalid
+	}
+
+	lower, err := strconv.Atoi(strings.TrimSpace(minPort))
+	if err != nil {
+		return 0, 0, invalid
+	}
+
+	upper, err := strconv.Atoi(strings.TrimSpace(maxPort))
+	if err != nil {
+		return 0, 0, invalid
+	}
+
+	if lower < 1 || lower > upper || upper > 65535 {
+		return 0, 0, invalid
+	}
+
+	return lower, upper, nil
+}
+
+// ParseNamespaces parses a comma separated list of namespaces, e.g. the
+// portforward-denied-namespaces, trimming the spaces around them and skipping
+// the empty ones, so "kube-system, " is kube-system only.
+func ParseNamespaces(namespaces string) []string {
+	parsed := []string{}
+
+	for _, namespace := range strings.Split(namespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			parsed = append(parsed, namespace)
+		}
+	}
+
+	return parsed
+}
+
 // Parse Loads the config from flags and env.
 // env vars should start with HEADLAMP_CONFIG_ and use _ as separator
 // If a value is set both in flags and env then flag takes priority.
@@ -260,11 +374,33 @@ func flagset() *flag.FlagSet {
 	f.String("oidc-scopes", "profile,email",
 		"A comma separated list of scopes needed from the OIDC provider")
 	f.Bool("oidc-use-access-token", false, "Setup oidc to pass through the access_token instead of the default id_token")
+	f.String("portforward-denied-namespaces", "kube-system",
+		"A comma separated list of namespaces port forwards are denied in unless explicitly allowed by the request")
+	f.String("portforward-store-unavailable-policy", "fail",
+		"What to do when the port forward state store is unavailable: fail with a 503, or fallback to memory")
+	f.String("portforward-port-range", "",
+		"The range of local ports picked for port forwards without a local port, e.g. 30000-30100; default is any port")
+	f.Int("portforward-max-per-cluster", defaultPortForwardMaxPerCluster,
+		"The maximum number of port forwards running at once in a cluster; 0 means no limit")
+	f.Int("portforward-max-start-retries", defaultPortForwardMaxStartRetries,
+		"The number of times starting a port forward is retried, with exponential backoff, when it fails to be ready")
+	f.Int("portforward-stopped-ttl-seconds", defaultPortForwardStoppedTTLSeconds,
+		"The time stopped port forwards are kept for before being deleted; 0 means they are kept")
+	f.Bool("portforward-allow-non-loopback-bind", false,
+		"Allow port forwards to listen on non-loopback addresses, e.g. 0.0.0.0, exposing them to the network")
+	f.Int("portforward-permission-check-timeout-seconds", defaultPortForwardPermissionCheckTimeoutSeconds,
+		"The time the permission check of a port forward can take before failing with a 504")
+	f.String("portforward-host-network-policy", "warn",
+		"What to do with port forwards to pods on the host network, which reach the node: warn, or reject them")
+	f.Int("portforward-dial-timeout-seconds", defaultPortForwardDialTimeoutSeconds,
+		"The time opening the connection of a port forward to the API server, its upgrade included, can take")
 	// Telemetry flags.
 	f.String("service-name", "headlamp", "Service name for telemetry")
 	f.String("service-version", "0.30.0", "Service version for telemetry")
 	f.Bool("tracing-enabled", false, "Enable distributed tracing")
 	f.Bool("metrics-enabled", false, "Enable metrics collection")
+	f.Bool("metrics-exemplars", false,
+		"Link metrics to the traces of sampled requests with exemplars, served in the OpenMetrics format")
 	f.String("otlp-endpoint", "localhost:4317", "OTLP collector endpoint")
 	f.Bool("use-otlp-http", false, "Use HTTP instead of gRPC for OTLP export")
 	f.Bool("stdout-trace-enabled", false, "Enable tracing output to stdout")
@@ -103,6 +103,116 @@ func TestParse(t *testing.T) {
 		assert.Equal(t, conf.KubeConfigPath, "~/.kube/test_config.yaml")
 	})
 
+	t.Run("portforward_port_range", func(t *testing.T) {
+		conf, err := config.Parse([]string{"go run ./cmd", "--portforward-port-range=30000-30100"})
+		require.NoError(t, err)
+		assert.Equal(t, "30000-30100", conf.PortForwardPortRange)
+
+		lower, upper, err := config.ParsePortRange(conf.PortForwardPortRange)
+		require.NoError(t, err)
+		assert.Equal(t, 30000, lower)
+		assert.Equal(t, 30100, upper)
+
+		for _, invalid := range []string{"30000", "30100-30000", "0-10", "1-65536", "a-b"} {
+			_, err := config.Parse([]string{"go run ./cmd", "--portforward-port-range=" + invalid})
+			require.Error(t, err, invalid)
+			assert.Contains(t, err.Error(), "portforward-port-range")
+		}
+	})
+
+	t.Run("portforward_denied_namespaces", func(t *testing.T) {
+		conf, err := config.Parse([]string{"go run ./cmd", "--portforward-denied-namespaces= kube-system, ,istio-system,"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"kube-system", "istio-system"}, config.ParseNamespaces(conf.PortForwardDeniedNamespaces))
+
+		assert.Empty(t, config.ParseNamespaces(""))
+		assert.Empty(t, config.ParseNamespaces(" , "))
+	})
+
+	t.Run("portforward_max_per_cluster", func(t *testing.T) {
+		conf, err := config.Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 100, conf.PortForwardMaxPerCluster)
+
+		conf, err = config.Parse([]string{"go run ./cmd", "--portforward-max-per-cluster=0"})
+		require.NoError(t, err)
+		assert.Equal(t, 0, conf.PortForwardMaxPerCluster)
+
+		_, err = config.Parse([]string{"go run ./cmd", "--portforward-max-per-cluster=-1"})
+		require.Error(t, err)
+	})
+
+	t.Run("portforward_max_start_retries", func(t *testing.T) {
+		conf, err := config.Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 2, conf.PortForwardMaxStartRetries)
+
+		_, err = config.Parse([]string{"go run ./cmd", "--portforward-max-start-retries=-1"})
+		require.Error(t, err)
+	})
+
+	t.Run("portforward_stopped_ttl_seconds", func(t *testing.T) {
+		conf, err := config.Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 3600, conf.PortForwardStoppedTTLSeconds)
+
+		conf, err = config.Parse([]string{"go run ./cmd", "--portforward-stopped-ttl-seconds=0"})
+		require.NoError(t, err)
+		assert.Equal(t, 0, conf.PortForwardStoppedTTLSeconds)
+
+		_, err = config.Parse([]string{"go run ./cmd", "--portforward-stopped-ttl-seconds=-1"})
+		require.Error(t, err)
+	})
+
+	t.Run("portforward_allow_non_loopback_bind", func(t *testing.T) {
+		conf, err := config.Parse(nil)
+		require.NoError(t, err)
+		assert.False(t, conf.PortForwardAllowNonLoopbackBind)
+
+		conf, err = config.Parse([]string{"go run ./cmd", "--portforward-allow-non-loopback-bind"})
+		require.NoError(t, err)
+		assert.True(t, conf.PortForwardAllowNonLoopbackBind)
+	})
+
+	t.Run("portforward_permission_check_timeout_seconds", func(t *testing.T) {
+		conf, err := config.Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 5, conf.PortForwardPermissionCheckTimeoutSeconds)
+
+		conf, err = config.Parse([]string{"go run ./cmd", "--portforward-permission-check-timeout-seconds=2"})
+		require.NoError(t, err)
+		assert.Equal(t, 2, conf.PortForwardPermissionCheckTimeoutSeconds)
+
+		_, err = config.Parse([]string{"go run ./cmd", "--portforward-permission-check-timeout-seconds=0"})
+		require.Error(t, err)
+	})
+
+	t.Run("portforward_host_network_policy", func(t *testing.T) {
+		conf, err := config.Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "warn", conf.PortForwardHostNetworkPolicy)
+
+		conf, err = config.Parse([]string{"go run ./cmd", "--portforward-host-network-policy=reject"})
+		require.NoError(t, err)
+		assert.Equal(t, "reject", conf.PortForwardHostNetworkPolicy)
+
+		_, err = config.Parse([]string{"go run ./cmd", "--portforward-host-network-policy=allow"})
+		require.Error(t, err)
+	})
+
+	t.Run("portforward_dial_timeout_seconds", func(t *testing.T) {
+		conf, err := config.Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 10, conf.PortForwardDialTimeoutSeconds)
+
+		conf, err = config.Parse([]string{"go run ./cmd", "--portforward-dial-timeout-seconds=3"})
+		require.NoError(t, err)
+		assert.Equal(t, 3, conf.PortForwardDialTimeoutSeconds)
+
+		_, err = config.Parse([]string{"go run ./cmd", "--portforward-dial-timeout-seconds=0"})
+		require.Error(t, err)
+	})
+
 	t.Run("enable_dynamic_clusters", func(t *testing.T) {
 		args := []string{
 			"go run ./cmd", "--enable-dynamic-clusters",
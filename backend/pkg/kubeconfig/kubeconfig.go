@@ -10,6 +10,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -148,8 +149,16 @@ func (e Base64Error) Error() string {
 		e.ContextName, e.ClusterName, e.UserName, strings.Join(messages, "\n"))
 }
 
+// clientConfigLock serializes the defaulting of the KubeContext, Cluster and
+// AuthInfo of the contexts by ClientConfig, as a context can be used by
+// concurrent requests.
+var clientConfigLock sync.Mutex
+
 // ClientConfig returns a clientcmd.ClientConfig for the context.
 func (c *Context) ClientConfig() clientcmd.ClientConfig {
+	clientConfigLock.Lock()
+	defer clientConfigLock.Unlock()
+
 	// If the context is empty, return nil.
 	if c.Name == "" && c.KubeContext == nil && c.Cluster == nil && c.AuthInfo == nil {
 		return nil
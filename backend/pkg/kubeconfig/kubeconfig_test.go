@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/config"
@@ -140,6 +141,30 @@ func TestLoadContextsWithDuplicateNames(t *testing.T) {
 	assert.Equal(t, 2, count, "Expected 2 contexts with the same name")
 }
 
+// TestClientConfigConcurrent tests the client config of a context can be
+// got concurrently, its missing parts being defaulted once.
+func TestClientConfigConcurrent(t *testing.T) {
+	testContext := &kubeconfig.Context{Name: "concurrent"}
+
+	var wg sync.WaitGroup
+
+	for range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			assert.NotNil(t, testContext.ClientConfig())
+		}()
+	}
+
+	wg.Wait()
+
+	require.NotNil(t, testContext.KubeContext)
+	require.NotNil(t, testContext.Cluster)
+	require.NotNil(t, testContext.AuthInfo)
+}
+
 func TestContext(t *testing.T) {
 	kubeConfigFile := config.GetDefaultKubeConfigPath()
 
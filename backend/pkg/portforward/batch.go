@@ -0,0 +1,358 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/kubeconfig"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// dependencyPollInterval is how often the status of the dependencies of a
+// batch port forward is checked while waiting for them to run.
+const dependencyPollInterval = 100 * time.Millisecond
+
+// bulkStartConcurrency is how many port forwards of a bulk start are started
+// at the same time.
+const bulkStartConcurrency = 4
+
+type batchStartRequest struct {
+	PortForwards []portForwardRequest `json:"portForwards"`
+	// RollbackOnCancel stops the port forwards already started by the batch
+	// when the request is canceled before the batch completes.
+	RollbackOnCancel bool `json:"rollbackOnCancel,omitempty"`
+}
+
+func (b *batchStartRequest) Validate() error {
+	if len(b.PortForwards) == 0 {
+		return newError(ErrCodeInvalidRequest, nil, "portForwards is required")
+	}
+
+	ids := []string{}
+
+	for i, p := range b.PortForwards {
+		if p.DryRun {
+			return newError(ErrCodeInvalidRequest, nil,
+				"portForwards[%d].dryRun isn't supported in batches, use the batch check instead", i)
+		}
+
+		for _, dependency := range p.DependsOn {
+			if !slices.Contains(ids, dependency) {
+				return newError(ErrCodeInvalidRequest, nil,
+					"portForwards[%d] depends on %q, which is not the id of an earlier port forward", i, dependency)
+			}
+		}
+
+		if p.ID != "" {
+			ids = append(ids, p.ID)
+		}
+	}
+
+	return nil
+}
+
+type batchStartFailure struct {
+	Request portForwardRequest `json:"request"`
+	Code    ErrorCode          `json:"code"`
+	Error   string             `json:"error"`
+}
+
+// batchStartResult is the summary of a batch start.
+type batchStartResult struct {
+	Started []portForwardRequest `json:"started"`
+	Failed  []batchStartFailure  `json:"failed"`
+	// Skipped are the requests not attempted because the batch was canceled.
+	Skipped []portForwardRequest `json:"skipped"`
+	// RolledBack are the ids of the started port forwards stopped because the
+	// batch was canceled.
+	RolledBack []string `json:"rolledBack"`
+	Canceled   bool     `json:"canceled"`
+}
+
+// waitForDependencies waits until the port forwards the request depends on
+// are running, as told by status. It fails if one of them failed to start,
+// stopped, or doesn't run within PortForwardReadinessTimeout.
+func waitForDependencies(ctx context.Context, p portForwardRequest, result batchStartResult,
+	status func(p portForwardRequest) string,
+) error {
+	deadline := time.Now().Add(PortForwardReadinessTimeout)
+
+	for _, dependency := range p.DependsOn {
+		i := slices.IndexFunc(result.Started, func(s portForwardRequest) bool { return s.ID == dependency })
+		if i < 0 {
+			return newError(ErrCodeDependencyNotReady, nil, "dependency %q failed to start", dependency)
+		}
+
+		if err := waitForDependency(ctx, result.Started[i], deadline, status); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForDependency polls the status of the dependency until it's running or the deadline passes.
+func waitForDependency(ctx context.Context, dependency portForwardRequest, deadline time.Time,
+	status func(p portForwardRequest) string,
+) error {
+	for {
+		switch status(dependency) {
+		case RUNNING:
+			return nil
+		case STOPPED:
+			return newError(ErrCodeDependencyNotReady, nil, "dependency %q stopped", dependency.ID)
+		}
+
+		if time.Now().After(deadline) {
+			return newError(ErrCodeDependencyNotReady, nil, "dependency %q never became ready", dependency.ID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return newError(ErrCodeStopped, ctx.Err(), "batch canceled waiting for dependency %q", dependency.ID)
+		case <-time.After(dependencyPollInterval):
+		}
+	}
+}
+
+// startPortForwardBatch starts the port forwards one after the other with start,
+// until ctx is done, each once the port forwards it depends on are running as
+// told by status. Once canceled, the remaining requests are skipped and, if
+// rollbackOnCancel is set, the port forwards already started are stopped with stop.
+func startPortForwardBatch(ctx context.Context, requests []portForwardRequest, rollbackOnCancel bool,
+	start func(p *portForwardRequest) (portForward, error),
+	stop func(p portForwardRequest) error,
+	status func(p portForwardRequest) string,
+) batchStartResult {
+	result := batchStartResult{
+		Started:    []portForwardRequest{},
+		Failed:     []batchStartFailure{},
+		Skipped:    []portForwardRequest{},
+		RolledBack: []string{},
+	}
+
+	for i := range requests {
+		if ctx.Err() != nil {
+			result.Canceled = true
+			result.Skipped = append(result.Skipped, requests[i:]...)
+
+			break
+		}
+
+		p := requests[i]
+
+		if err := waitForDependencies(ctx, p, result, status); err != nil {
+			result.Failed = append(result.Failed, batchStartFailure{Request: p, Code: errorCode(err), Error: err.Error()})
+
+			continue
+		}
+
+		pf, err := start(&p)
+		if err != nil {
+			result.Failed = append(result.Failed, batchStartFailure{Request: p, Code: errorCode(err), Error: err.Error()})
+
+			continue
+		}
+
+		p.setBound(pf)
+		result.Started = append(result.Started, p)
+	}
+
+	// The last start might have been interrupted by the cancellation too.
+	if ctx.Err() != nil {
+		result.Canceled = true
+	}
+
+	if !result.Canceled || !rollbackOnCancel {
+		return result
+	}
+
+	for _, p := range result.Started {
+		if err := stop(p); err != nil {
+			logger.Log(logger.LevelError, map[string]string{"id": p.ID}, err, "rolling back batch portforward")
+
+			continue
+		}
+
+		result.RolledBack = append(result.RolledBack, p.ID)
+	}
+
+	return result
+}
+
+// StartPortForwards handles the batch port forward request, starting several
+// port forwards at once. Starting stops early if the client goes away.
+func StartPortForwards(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}],
+	w http.ResponseWriter, r *http.Request,
+) {
+	var b batchStartRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding batch portforward payload")
+		http.Error(w, "failed to marshal batch port forward payload "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := b.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating batch portforward payload")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	result := startPortForwardBatch(r.Context(), b.PortForwards, b.RollbackOnCancel,
+		func(p *portForwardRequest) (portForward, error) {
+			return startPortForwardRequest(kubeConfigStore, cache, p, r)
+		},
+		func(p portForwardRequest) error {
+			return stopOrDeletePortForward(cache, userClusterName(r, p.Cluster), p.ID, true)
+		},
+		func(p portForwardRequest) string {
+			pf, err := getPortForwardByID(cache, userClusterName(r, p.Cluster), p.ID)
+			if err != nil {
+				return STOPPED
+			}
+
+			return pf.Status
+		},
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// bulkStartResult is the result of starting one of the port forwards of a
+// bulk start, either the port forward started or the error it failed with.
+type bulkStartResult struct {
+	PortForward *portForward `json:"portForward,omitempty"`
+	Code        ErrorCode    `json:"code,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// startPortForwardBulk starts the port forwards with start, concurrency of them
+// at the same time, and returns their results in the order of the requests.
+// Unlike batches, they are independent: a failing one doesn't affect the
+// others, and once ctx is done the remaining ones fail without being started.
+func startPortForwardBulk(ctx context.Context, requests []portForwardRequest, concurrency int,
+	start func(p *portForwardRequest) (portForward, error),
+) []bulkStartResult {
+	results := make([]bulkStartResult, len(requests))
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+
+	for i := range requests {
+		p := requests[i]
+
+		var err error
+
+		switch {
+		case p.DryRun:
+			err = newError(ErrCodeInvalidRequest, nil, "dryRun isn't supported in bulk starts")
+		case len(p.DependsOn) > 0:
+			err = newError(ErrCodeInvalidRequest, nil, "dependsOn isn't supported in bulk starts, use a batch instead")
+		case ctx.Err() != nil:
+			err = newError(ErrCodeStopped, ctx.Err(), "bulk start canceled")
+		}
+
+		if err == nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				err = newError(ErrCodeStopped, ctx.Err(), "bulk start canceled")
+			}
+		}
+
+		if err != nil {
+			results[i] = bulkStartResult{Code: errorCode(err), Error: err.Error()}
+
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			pf, err := start(&p)
+			if err != nil {
+				results[i] = bulkStartResult{Code: errorCode(err), Error: err.Error()}
+
+				return
+			}
+
+			results[i] = bulkStartResult{PortForward: &pf}
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// StartPortForwardsBulk handles the bulk port forward request, starting the
+// port forwards of the array of requests concurrently. It responds with the
+// array of their results, in the same order, a failing request not
+// preventing the others from being started.
+func StartPortForwardsBulk(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}],
+	w http.ResponseWriter, r *http.Request,
+) {
+	var requests []portForwardRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding bulk portforward payload")
+		http.Error(w, "failed to marshal bulk port forward payload "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if len(requests) == 0 {
+		http.Error(w, "at least one port forward is required", http.StatusBadRequest)
+
+		return
+	}
+
+	results := startPortForwardBulk(r.Context(), requests, bulkStartConcurrency,
+		func(p *portForwardRequest) (portForward, error) {
+			return startPortForwardRequest(kubeConfigStore, cache, p, r)
+		},
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
@@ -0,0 +1,342 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/kubeconfig"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// targetCheck is the result of one of the checks of a port forward target.
+type targetCheck struct {
+	OK    bool      `json:"ok"`
+	Code  ErrorCode `json:"code,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// newTargetCheck returns the result of a check which failed with err, if not nil.
+func newTargetCheck(err error) *targetCheck {
+	if err != nil {
+		return &targetCheck{Code: errorCode(err), Error: err.Error()}
+	}
+
+	return &targetCheck{OK: true}
+}
+
+// batchCheckResult is the readiness of a port forward target. The checks
+// other than Valid are only run, and set, if the request is valid.
+type batchCheckResult struct {
+	Request portForwardRequest `json:"request"`
+	// Pod is the pod the port forward would target, if it could be resolved.
+	Pod           string       `json:"pod,omitempty"`
+	Valid         *targetCheck `json:"valid"`
+	PodRunning    *targetCheck `json:"podRunning,omitempty"`
+	Permission    *targetCheck `json:"permission,omitempty"`
+	PortAvailable *targetCheck `json:"portAvailable,omitempty"`
+	// Ready tells whether all the checks passed.
+	Ready bool `json:"ready"`
+}
+
+// batchCheckReport is the readiness matrix of the targets of a batch.
+type batchCheckReport struct {
+	Targets []batchCheckResult `json:"targets"`
+	// Ready tells whether all the targets are ready.
+	Ready bool `json:"ready"`
+}
+
+// checkTargetPod resolves the pod the port forward would target the way
+// starting it does, and checks it is running. The target is returned without
+// its pod if the pod isn't found.
+func checkTargetPod(ctx context.Context, clientset kubernetes.Interface, p portForwardRequest) (accessTarget, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	defer cancel()
+
+	namespace := p.Namespace
+	pod := p.Pod
+	service := ""
+
+	sel := p.podSelection()
+
+	switch {
+	case p.ServicePort != "" || (p.Service != "" && p.Pod == "" && sel.isEmpty()):
+		if p.ServiceNamespace != "" {
+			namespace = p.ServiceNamespace
+		}
+
+		service = p.Service
+
+		resolved, _, _, err := resolveServiceRequest(ctx, clientset, namespace, p, p.PodSelectionStrategy)
+		if err != nil {
+			return accessTarget{namespace: namespace, service: service}, err
+		}
+
+		pod = resolved.Name
+	case !sel.isEmpty():
+		resolved, err := selectPod(ctx, clientset, namespace, p.Pod, sel)
+		if err != nil {
+			return accessTarget{namespace: namespace}, err
+		}
+
+		pod = resolved.Name
+	}
+
+	target := accessTarget{namespace: namespace, pod: pod, service: service}
+
+	if err := checkIfPodIsRunning(ctx, clientset, namespace, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			target.pod = ""
+
+			return target, newError(ErrCodeNotFound, err, "getting pod %s/%s", namespace, pod)
+		}
+
+		return target, newError(ErrCodeStopped, err, "pod %s/%s", namespace, pod)
+	}
+
+	return target, nil
+}
+
+// checkPortAvailable checks the local ports of the port forward can be listened
+// on, by binding them and closing the listeners right away.
+func checkPortAvailable(p portForwardRequest) error {
+	for _, pair := range p.portPairs() {
+		if pair.Port == "" {
+			// A free port is picked when starting.
+			continue
+		}
+
+		listener, err := listenLocal(p.localAddresses(), pair.Port, "", listenOptions{reusePort: p.ReusePort})
+		if isAddrInUse(err) {
+			return newError(ErrCodePortUnavailable, nil, "local port %s is already in use", pair.Port)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		listener.Close()
+	}
+
+	return nil
+}
+
+// duplicatePort returns a local port of the port forward already requested by
+// an earlier port forward of the batch, and the index of the latter.
+func duplicatePort(p portForwardRequest, ports map[string]int) (string, int, bool) {
+	for _, pair := range p.portPairs() {
+		if first, ok := ports[pair.Port]; ok {
+			return pair.Port, first, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// checkPortForwardTargets checks, without starting anything, whether each of
+// the port forwards is valid, targets a running pod the user is allowed to
+// port forward to, and has an available local port. The clients of the
+// clusters of the port forwards are returned by clients. The checks stop once ctx is done.
+func checkPortForwardTargets(ctx context.Context, requests []portForwardRequest,
+	clients func(p portForwardRequest) (kubernetes.Interface, error),
+) batchCheckReport {
+	report := batchCheckReport{Targets: []batchCheckResult{}, Ready: true}
+	ports := map[string]int{}
+
+	for i, p := range requests {
+		result := batchCheckResult{Request: p}
+
+		clientset, err := checkTargetRequest(p, clients)
+
+		result.Valid = newTargetCheck(err)
+		if err == nil {
+			target, err := checkTargetPod(ctx, clientset, p)
+			result.Pod = target.pod
+			result.PodRunning = newTargetCheck(err)
+
+			if target.pod == "" {
+				target.pod = p.Pod
+			}
+
+			result.Permission = newTargetCheck(checkPortForwardPermission(ctx, clientset, target))
+
+			if port, first, ok := duplicatePort(p, ports); ok {
+				result.PortAvailable = newTargetCheck(newError(ErrCodePortUnavailable, nil,
+					"port %s is also requested by portForwards[%d]", port, first))
+			} else {
+				result.PortAvailable = newTargetCheck(checkPortAvailable(p))
+
+				for _, pair := range p.portPairs() {
+					if pair.Port != "" {
+						ports[pair.Port] = i
+					}
+				}
+			}
+		}
+
+		result.Ready = result.Valid.OK && result.PodRunning.OK && result.Permission.OK && result.PortAvailable.OK
+		report.Ready = report.Ready && result.Ready
+		report.Targets = append(report.Targets, result)
+	}
+
+	return report
+}
+
+// checkTargetRequest validates the request and returns the client of its cluster.
+func checkTargetRequest(p portForwardRequest,
+	clients func(p portForwardRequest) (kubernetes.Interface, error),
+) (kubernetes.Interface, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if isDeniedNamespace(p.Namespace) && !p.AllowSystemNamespace {
+		return nil, newError(ErrCodeForbidden, nil, "port forwarding in the %s namespace is denied, "+
+			"set allowSystemNamespace to forward to it anyway", p.Namespace)
+	}
+
+	if err := p.checkBindPolicy(); err != nil {
+		return nil, err
+	}
+
+	return clients(p)
+}
+
+// CheckPortForwards handles the batch check request, telling for each of the
+// port forwards of a batch whether it could be started, without starting any.
+func CheckPortForwards(kubeConfigStore kubeconfig.ContextStore, w http.ResponseWriter, r *http.Request) {
+	var b batchStartRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding batch portforward payload")
+		http.Error(w, "failed to marshal batch port forward payload "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := b.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating batch portforward payload")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	token := bearerToken(r)
+
+	impersonate, err := impersonation(r)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating batch portforward impersonation")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	clients := func(p portForwardRequest) (kubernetes.Interface, error) {
+		kContext, err := kubeConfigStore.GetContext(userClusterName(r, p.Cluster))
+		if err != nil {
+			return nil, newError(ErrCodeNotFound, err, "cluster %s not found", p.Cluster)
+		}
+
+		clientset, _, err := getKubeClientAndConfig(kContext, token, impersonate)
+		if err != nil {
+			return nil, newError(ErrCodeInternal, err, "failed to setup Kubernetes client/config")
+		}
+
+		return clientset, nil
+	}
+
+	report := checkPortForwardTargets(r.Context(), b.PortForwards, clients)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// permissionResult tells whether the user is allowed to port forward, and why not.
+type permissionResult struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// CheckPortForwardPermission handles the permission check request, telling
+// whether the user is allowed to port forward to the pod query param, any pod
+// of the namespace if empty, and to resolve the service query param if set,
+// without port forwarding.
+func CheckPortForwardPermission(kubeConfigStore kubeconfig.ContextStore, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cluster := query.Get("cluster")
+	target := accessTarget{namespace: query.Get("namespace"), pod: query.Get("pod"), service: query.Get("service")}
+
+	if cluster == "" || target.namespace == "" {
+		writeError(w, newError(ErrCodeInvalidRequest, nil, "cluster and namespace are required"))
+
+		return
+	}
+
+	logParams := map[string]string{"cluster": cluster, "namespace": target.namespace, "pod": target.pod}
+
+	kContext, err := kubeConfigStore.GetContext(userClusterName(r, cluster))
+	if err != nil {
+		logger.Log(logger.LevelError, logParams, err, "getting kubeconfig context")
+		writeError(w, newError(ErrCodeNotFound, err, "cluster %s not found", cluster))
+
+		return
+	}
+
+	impersonate, err := impersonation(r)
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	clientset, _, err := getKubeClientAndConfig(kContext, bearerToken(r), impersonate)
+	if err != nil {
+		logger.Log(logger.LevelError, logParams, err, "checking portforward permission")
+		writeError(w, newError(ErrCodeInternal, err, "failed to setup Kubernetes client/config"))
+
+		return
+	}
+
+	result := permissionResult{Allowed: true}
+
+	if err := checkPortForwardPermission(r.Context(), clientset, target); err != nil {
+		if errorCode(err) != ErrCodeForbidden {
+			logger.Log(logger.LevelError, logParams, err, "checking portforward permission")
+			writeError(w, unreachableError(err))
+
+			return
+		}
+
+		result = permissionResult{Reason: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+// DeniedNamespaces are the namespaces port forwards are refused in, unless
+// the request explicitly sets allowSystemNamespace. It is set from the
+// portforward-denied-namespaces config and defaults to kube-system.
+var DeniedNamespaces = []string{"kube-system"}
+
+const (
+	// StoreUnavailableFail fails the port forward operations with a 503 while
+	// the cache backend is unavailable.
+	StoreUnavailableFail = "fail"
+	// StoreUnavailableFallback keeps an in-memory mirror of the port forwards,
+	// used while the cache backend is unavailable.
+	StoreUnavailableFallback = "fallback"
+)
+
+// StoreUnavailablePolicy is what to do when the cache backend holding the port
+// forwards is unavailable, StoreUnavailableFail or StoreUnavailableFallback.
+// It is set from the portforward-store-unavailable-policy config.
+var StoreUnavailablePolicy = StoreUnavailableFail
+
+// PortRangeMin and PortRangeMax are the range of the local ports picked for
+// the port forwards without a local port. They are set from the
+// portforward-port-range config, and any free port is picked when unset.
+var (
+	PortRangeMin int
+	PortRangeMax int
+)
+
+// DefaultMaxPortForwardsPerCluster is the default of MaxPortForwardsPerCluster.
+const DefaultMaxPortForwardsPerCluster = 100
+
+// MaxPortForwardsPerCluster is the most port forwards which can be running
+// at once in a cluster, none if 0. It is set from the
+// portforward-max-per-cluster config and defaults to DefaultMaxPortForwardsPerCluster.
+var MaxPortForwardsPerCluster = DefaultMaxPortForwardsPerCluster
+
+// DefaultMaxStartRetries is the default of MaxStartRetries.
+const DefaultMaxStartRetries = 2
+
+// MaxStartRetries is the number of times starting a port forward is retried
+// when its tunnel fails to become ready. It is set from the
+// portforward-max-start-retries config and defaults to DefaultMaxStartRetries.
+var MaxStartRetries = DefaultMaxStartRetries
+
+// DefaultStoppedTTL is the default of StoppedTTL.
+const DefaultStoppedTTL = time.Hour
+
+// StoppedTTL is how long stopped port forwards are kept before being reaped,
+// forever if 0. It is set from the portforward-stopped-ttl-seconds config and
+// defaults to DefaultStoppedTTL.
+var StoppedTTL = DefaultStoppedTTL
+
+// DefaultPermissionCheckTimeout is the default of PermissionCheckTimeout.
+const DefaultPermissionCheckTimeout = 5 * time.Second
+
+// PermissionCheckTimeout bounds the permission checks of the port forwards,
+// so a slow or unreachable API server fails them fast. It is set from the
+// portforward-permission-check-timeout-seconds config and defaults to
+// DefaultPermissionCheckTimeout.
+var PermissionCheckTimeout = DefaultPermissionCheckTimeout
+
+// DefaultDialTimeout is the default of DialTimeout.
+const DefaultDialTimeout = 10 * time.Second
+
+// DialTimeout bounds opening the connection of a tunnel to the API server,
+// its SPDY upgrade included, so a hung upgrade fails the tunnel instead of
+// waiting for the readiness timeout. It is set from the
+// portforward-dial-timeout-seconds config and defaults to DefaultDialTimeout.
+var DialTimeout = DefaultDialTimeout
+
+// AllowNonLoopbackBind lets the port forwards listen on addresses other than
+// the loopback ones, e.g. 0.0.0.0 exposing them to the network. It is set from
+// the portforward-allow-non-loopback-bind config and is off by default, the
+// requests for other addresses being forbidden.
+var AllowNonLoopbackBind bool
+
+const (
+	// HostNetworkWarn starts the port forwards to the pods on the host network
+	// with a warning, their ports being the ones of their node.
+	HostNetworkWarn = "warn"
+	// HostNetworkReject refuses the port forwards to the pods on the host network.
+	HostNetworkReject = "reject"
+)
+
+// HostNetworkPolicy is what to do with the port forwards to the pods on the
+// host network, HostNetworkWarn or HostNetworkReject. It is set from the
+// portforward-host-network-policy config.
+var HostNetworkPolicy = HostNetworkWarn
+
+// isLoopbackAddress tells whether the local address is localhost or a loopback IP.
+func isLoopbackAddress(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(address)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// isDeniedNamespace tells whether namespace is one of the DeniedNamespaces.
+func isDeniedNamespace(namespace string) bool {
+	return namespace != "" && slices.Contains(DeniedNamespaces, namespace)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/moby/spdystream"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// ErrStreamLimitReached is reported when no new stream can be opened on the
+// SPDY connection of a port forward, usually because too many connections
+// are being forwarded at once.
+var ErrStreamLimitReached = errors.New("SPDY stream limit reached")
+
+// streamTrackingDialer wraps a httpstream.Dialer so that the protocol negotiated
+// with the apiserver, or the error dialing it, is reported to onDial, and
+// failures creating streams on the dialed connection are reported to onStreamError.
+type streamTrackingDialer struct {
+	httpstream.Dialer
+	// timeout bounds Dial, if set.
+	timeout       time.Duration
+	onDial        func(protocol string, err error)
+	onStreamError func(err error)
+}
+
+// errUpgradeTimeout is the error of the dials not upgraded within their timeout.
+var errUpgradeTimeout = errors.New("portforward connection upgrade timed out")
+
+// Dial opens the streaming connection and wraps it to track stream errors.
+func (d *streamTrackingDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	conn, protocol, err := d.dial(protocols...)
+
+	if d.onDial != nil {
+		d.onDial(protocol, err)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &streamTrackingConnection{Connection: conn, onStreamError: d.onStreamError}, protocol, nil
+}
+
+// dial opens the streaming connection, failing with errUpgradeTimeout if it
+// isn't within the timeout of the dialer. The upgrade response is read from the
+// connection without a deadline, so it's given up on rather than canceled,
+// the connection being closed if it's eventually opened.
+func (d *streamTrackingDialer) dial(protocols ...string) (httpstream.Connection, string, error) {
+	if d.timeout <= 0 {
+		return d.Dialer.Dial(protocols...)
+	}
+
+	type dialResult struct {
+		conn     httpstream.Connection
+		protocol string
+		err      error
+	}
+
+	results := make(chan dialResult, 1)
+
+	go func() {
+		conn, protocol, err := d.Dialer.Dial(protocols...)
+		results <- dialResult{conn: conn, protocol: protocol, err: err}
+	}()
+
+	timer := time.NewTimer(d.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		return r.conn, r.protocol, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+
+		return nil, "", fmt.Errorf("%w after %s", errUpgradeTimeout, d.timeout)
+	}
+}
+
+// streamTrackingConnection is a httpstream.Connection reporting the errors
+// of CreateStream. The port forwarder creates two streams per local
+// connection, so this is where exhausting the connection shows up.
+type streamTrackingConnection struct {
+	httpstream.Connection
+	onStreamError func(err error)
+}
+
+// CreateStream creates a new stream, classifying and reporting any failure.
+func (c *streamTrackingConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	stream, err := c.Connection.CreateStream(headers)
+	if err != nil {
+		if isStreamLimitError(err) {
+			err = errors.Join(ErrStreamLimitReached, err)
+		}
+
+		if c.onStreamError != nil {
+			c.onStreamError(err)
+		}
+	}
+
+	return stream, err
+}
+
+// isStreamLimitError tells whether err means the SPDY connection can't take
+// any more streams: the stream ids ran out, or the server didn't accept the
+// stream in time or refused it.
+func isStreamLimitError(err error) bool {
+	return errors.Is(err, spdystream.ErrTimeout) ||
+		errors.Is(err, spdystream.ErrReset) ||
+		strings.Contains(err.Error(), "Unable to get new stream id")
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// ErrorCode classifies the failures of port forward operations.
+type ErrorCode string
+
+const (
+	// ErrCodeInvalidRequest is for requests failing validation.
+	ErrCodeInvalidRequest ErrorCode = "INVALID_REQUEST"
+	// ErrCodeForbidden is for port forwards the user isn't allowed to make.
+	ErrCodeForbidden ErrorCode = "FORBIDDEN"
+	// ErrCodeNotFound is for unknown clusters, pods and port forwards.
+	ErrCodeNotFound ErrorCode = "NOT_FOUND"
+	// ErrCodePortUnavailable is for local ports which can't be listened on.
+	ErrCodePortUnavailable ErrorCode = "PORT_UNAVAILABLE"
+	// ErrCodeStopped is for port forwards stopped while starting.
+	ErrCodeStopped ErrorCode = "STOPPED"
+	// ErrCodeReadinessTimeout is for port forwards not becoming ready in time.
+	ErrCodeReadinessTimeout ErrorCode = "READINESS_TIMEOUT"
+	// ErrCodePermissionCheckTimeout is for permission checks not answered
+	// within PermissionCheckTimeout.
+	ErrCodePermissionCheckTimeout ErrorCode = "PERMISSION_CHECK_TIMEOUT"
+	// ErrCodeDependencyNotReady is for batch port forwards whose dependencies aren't running.
+	ErrCodeDependencyNotReady ErrorCode = "DEPENDENCY_NOT_READY"
+	// ErrCodeStoreUnavailable is for failures of the cache backend holding the port forwards.
+	ErrCodeStoreUnavailable ErrorCode = "STORE_UNAVAILABLE"
+	// ErrCodeLimitReached is for port forwards over MaxPortForwardsPerCluster.
+	ErrCodeLimitReached ErrorCode = "LIMIT_REACHED"
+	// ErrCodeUnreachable is for API servers which couldn't be connected to,
+	// which is worth retrying.
+	ErrCodeUnreachable ErrorCode = "UNREACHABLE"
+	// ErrCodeInternal is for any other failure.
+	ErrCodeInternal ErrorCode = "INTERNAL"
+)
+
+// errorCodeStatus maps the error codes to HTTP status codes.
+var errorCodeStatus = map[ErrorCode]int{
+	ErrCodeInvalidRequest:         http.StatusBadRequest,
+	ErrCodeForbidden:              http.StatusForbidden,
+	ErrCodeNotFound:               http.StatusNotFound,
+	ErrCodePortUnavailable:        http.StatusConflict,
+	ErrCodeStopped:                http.StatusConflict,
+	ErrCodeReadinessTimeout:       http.StatusGatewayTimeout,
+	ErrCodePermissionCheckTimeout: http.StatusGatewayTimeout,
+	ErrCodeDependencyNotReady:     http.StatusFailedDependency,
+	ErrCodeStoreUnavailable:       http.StatusServiceUnavailable,
+	ErrCodeLimitReached:           http.StatusTooManyRequests,
+	ErrCodeUnreachable:            http.StatusServiceUnavailable,
+	ErrCodeInternal:               http.StatusInternalServerError,
+}
+
+// PortForwardError is the error returned by the port forward operations,
+// letting callers switch on the kind of failure with its Code.
+type PortForwardError struct {
+	Code    ErrorCode
+	Message string
+	// Cause is the underlying error, if any.
+	Cause error
+}
+
+func (e *PortForwardError) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Cause.Error()
+}
+
+func (e *PortForwardError) Unwrap() error {
+	return e.Cause
+}
+
+// HTTPStatus returns the HTTP status code for the error.
+func (e *PortForwardError) HTTPStatus() int {
+	if status, ok := errorCodeStatus[e.Code]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// newError returns a PortForwardError with the formatted message and cause, which may be nil.
+func newError(code ErrorCode, cause error, format string, args ...interface{}) *PortForwardError {
+	return &PortForwardError{Code: code, Message: fmt.Sprintf(format, args...), Cause: cause}
+}
+
+// errorCode returns the code of the PortForwardError in err's chain. Without
+// one, the not found and forbidden API errors are classified as such, and
+// anything else is ErrCodeInternal.
+func errorCode(err error) ErrorCode {
+	var pfErr *PortForwardError
+	if errors.As(err, &pfErr) {
+		return pfErr.Code
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return ErrCodeNotFound
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return ErrCodeForbidden
+	}
+
+	return ErrCodeInternal
+}
+
+// retryAfterSeconds is the Retry-After of the responses to retry later.
+const retryAfterSeconds = "5"
+
+// isConnectionError tells whether err is a failure to connect, i.e. resolving
+// the host, dialing it, a TLS handshake timing out or the connection being
+// closed, rather than an error of the server or the configuration.
+func isConnectionError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	// The remote and local errors of TLS handshakes, e.g. bad certificates, aren't transient.
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read" || opErr.Op == "write") {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err)
+}
+
+// unreachableError returns err as an ErrCodeUnreachable error if it's a
+// connection error without a more specific code.
+func unreachableError(err error) error {
+	if errorCode(err) != ErrCodeInternal || !isConnectionError(err) {
+		return err
+	}
+
+	return newError(ErrCodeUnreachable, err, "API server unreachable")
+}
+
+// errorStatus returns the HTTP status code for err.
+func errorStatus(err error) int {
+	if status, ok := errorCodeStatus[errorCode(err)]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// errorResponse is the body of the port forward error responses.
+type errorResponse struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// writeError responds with the status of err and a JSON body with its code and
+// message. Unavailable services are told to be retried later.
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+	}
+
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(errorResponse{Code: errorCode(err), Message: err.Error()}); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing error payload to response")
+	}
+}
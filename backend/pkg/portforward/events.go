@@ -0,0 +1,280 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// eventBufferSize is the number of events buffered for a subscriber. Events
+// are dropped for subscribers which don't keep up.
+const eventBufferSize = 64
+
+// eventKeepAliveInterval is how often a comment is written to the event
+// streams, so idle connections aren't closed by proxies.
+var eventKeepAliveInterval = 30 * time.Second
+
+// deletedStatus is the status of the event of a port forward deleted, ending
+// the streams of the subscribers to that port forward.
+const deletedStatus = "Deleted"
+
+// statusEvent is a status transition of a port forward.
+type statusEvent struct {
+	ID      string    `json:"id"`
+	Cluster string    `json:"cluster"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// eventSubscriber receives the status events of the port forwards of a
+// cluster, or only of the one with id if set.
+type eventSubscriber struct {
+	cluster string
+	id      string
+	events  chan statusEvent
+}
+
+// wants tells whether the subscriber receives the events of the port forward.
+func (s *eventSubscriber) wants(p portForward) bool {
+	return s.cluster == p.Cluster && (s.id == "" || s.id == p.ID)
+}
+
+// eventHub publishes the status transitions of the port forwards of a cache
+// backend to their subscribers.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	// last is the last published status and error of each port forward, by key.
+	last map[string]statusEvent
+}
+
+var (
+	eventHubsLock sync.Mutex
+	// eventHubs are the event hubs of the cache backends.
+	eventHubs = map[cache.Cache[interface{}]]*eventHub{}
+)
+
+// getEventHub returns the event hub of the cache backend.
+func getEventHub(backend cache.Cache[interface{}]) *eventHub {
+	eventHubsLock.Lock()
+	defer eventHubsLock.Unlock()
+
+	h, ok := eventHubs[backend]
+	if !ok {
+		h = &eventHub{subscribers: map[*eventSubscriber]struct{}{}, last: map[string]statusEvent{}}
+		eventHubs[backend] = h
+	}
+
+	return h
+}
+
+// subscribe registers a subscriber to the status events of the cluster, or
+// only of the port forward with id if not empty.
+func (h *eventHub) subscribe(cluster, id string) *eventSubscriber {
+	s := &eventSubscriber{cluster: cluster, id: id, events: make(chan statusEvent, eventBufferSize)}
+
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+
+	return s
+}
+
+// unsubscribe removes the subscriber, which gets no more events.
+func (h *eventHub) unsubscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, s)
+	h.mu.Unlock()
+}
+
+// publish sends the status of the port forward to the subscribers of its
+// cluster, if its status or error changed since it was last published.
+func (h *eventHub) publish(p portForward) {
+	key := portforwardKeyGenerator(p)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if last, ok := h.last[key]; ok && last.Status == p.Status && last.Error == p.Error {
+		return
+	}
+
+	event := statusEvent{ID: p.ID, Cluster: p.ClusterName, Status: p.Status, Error: p.Error, Time: time.Now()}
+	h.last[key] = event
+
+	for s := range h.subscribers {
+		if s.wants(p) {
+			h.send(s, event)
+		}
+	}
+}
+
+// send sends the event to the subscriber, dropping it if the subscriber
+// doesn't keep up. It is called with mu held.
+func (h *eventHub) send(s *eventSubscriber, event statusEvent) {
+	select {
+	case s.events <- event:
+	default:
+		logger.Log(logger.LevelWarn, map[string]string{"id": event.ID, "status": event.Status},
+			nil, "dropping portforward status event of a slow subscriber")
+	}
+}
+
+// forget drops the last published status of the port forward, once deleted,
+// and tells the subscribers to that port forward it was.
+func (h *eventHub) forget(p portForward) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.last, portforwardKeyGenerator(p))
+
+	event := statusEvent{ID: p.ID, Cluster: p.ClusterName, Status: deletedStatus, Time: time.Now()}
+
+	for s := range h.subscribers {
+		if s.id != "" && s.wants(p) {
+			h.send(s, event)
+		}
+	}
+}
+
+// writeEvent writes the status event as a Server-Sent Events frame.
+func writeEvent(w http.ResponseWriter, event statusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+
+	return err
+}
+
+// streamPortForwardEvents writes the status events of the port forwards of
+// the cluster, or only of the one with id if not empty, to w until the
+// request is done. The current status of each port forward is written first.
+// The stream of a port forward ends once it is deleted.
+func streamPortForwardEvents(cache cache.Cache[interface{}], cluster, id string,
+	w http.ResponseWriter, r *http.Request,
+) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return newError(ErrCodeInternal, nil, "streaming is not supported")
+	}
+
+	hub := getEventHub(cache)
+
+	// Subscribing before listing so no transition is missed in between.
+	s := hub.subscribe(cluster, id)
+	defer hub.unsubscribe(s)
+
+	portForwards, err := subscribedPortForwards(cache, cluster, id)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, pf := range portForwards {
+		event := statusEvent{ID: pf.ID, Cluster: pf.ClusterName, Status: pf.Status, Error: pf.Error, Time: time.Now()}
+		if err := writeEvent(w, event); err != nil {
+			return err
+		}
+	}
+
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case event := <-s.events:
+			if err := writeEvent(w, event); err != nil {
+				return err
+			}
+
+			flusher.Flush()
+
+			if event.Status == deletedStatus {
+				return nil
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return err
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// subscribedPortForwards returns the port forwards of the cluster, or the one
+// with id if not empty.
+func subscribedPortForwards(cache cache.Cache[interface{}], cluster, id string) ([]portForward, error) {
+	if id == "" {
+		return getPortForwardList(cache, cluster)
+	}
+
+	pf, err := getPortForwardByID(cache, cluster, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return []portForward{pf}, nil
+}
+
+// GetPortForwardEvents handles the port forward events request, streaming
+// the status transitions of the port forwards of a cluster as Server-Sent Events.
+// With the id query param, only the lifecycle of that port forward is
+// streamed, until it is deleted.
+func GetPortForwardEvents(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	cluster := r.URL.Query().Get("cluster")
+	id := r.URL.Query().Get("id")
+
+	if cluster == "" {
+		logger.Log(logger.LevelError, nil, errors.New("cluster is required"), "streaming portforward events")
+		http.Error(w, "cluster is required", http.StatusBadRequest)
+
+		return
+	}
+
+	err := streamPortForwardEvents(cache, userClusterName(r, cluster), id, w, r)
+	if err == nil {
+		return
+	}
+
+	logger.Log(logger.LevelError, map[string]string{"cluster": cluster, "id": id}, err, "streaming portforward events")
+
+	// Errors can only be reported before the stream started.
+	if w.Header().Get("Content-Type") != "text/event-stream" {
+		http.Error(w, err.Error(), errorStatus(err))
+	}
+}
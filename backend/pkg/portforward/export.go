@@ -0,0 +1,223 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// exportedPortForward is a port forward as exported by ExportPortForwards:
+// its target and the options it was started with, without its id nor its
+// state. Its fields are the ones of portForwardRequest, so an export can be
+// started again as is with StartPortForwardsBulk.
+type exportedPortForward struct {
+	Name             string     `json:"name,omitempty"`
+	Cluster          string     `json:"cluster"`
+	Namespace        string     `json:"namespace"`
+	Pod              string     `json:"pod,omitempty"`
+	Service          string     `json:"service,omitempty"`
+	ServiceNamespace string     `json:"serviceNamespace,omitempty"`
+	ServicePort      string     `json:"servicePort,omitempty"`
+	TargetPort       string     `json:"targetPort,omitempty"`
+	Port             string     `json:"port,omitempty"`
+	Ports            []PortPair `json:"ports,omitempty"`
+	Container        string     `json:"container,omitempty"`
+	Addresses        []string   `json:"addresses,omitempty"`
+	BindAddress      string     `json:"bindAddress,omitempty"`
+	Interface        string     `json:"interface,omitempty"`
+	// The pod selection, the pod being picked again when started.
+	PodTemplateHash      string `json:"podTemplateHash,omitempty"`
+	PodAnnotationKey     string `json:"podAnnotationKey,omitempty"`
+	PodAnnotationValue   string `json:"podAnnotationValue,omitempty"`
+	CronJob              string `json:"cronJob,omitempty"`
+	LabelSelector        string `json:"labelSelector,omitempty"`
+	PodSelectionStrategy string `json:"podSelectionStrategy,omitempty"`
+	AutoReconnect        bool   `json:"autoReconnect,omitempty"`
+	TargetKind           string `json:"targetKind,omitempty"`
+	TargetName           string `json:"targetName,omitempty"`
+	// The options of the port forward.
+	EntryTTLSeconds              int  `json:"entryTTLSeconds,omitempty"`
+	ReusePort                    bool `json:"reusePort,omitempty"`
+	ConnectionIdleTimeoutSeconds int  `json:"connectionIdleTimeoutSeconds,omitempty"`
+	MaxConnections               int  `json:"maxConnections,omitempty"`
+	ReadinessTimeoutSeconds      int  `json:"readinessTimeoutSeconds,omitempty"`
+	IdleTimeoutSeconds           int  `json:"idleTimeoutSeconds,omitempty"`
+	LivenessCheck                bool `json:"livenessCheck,omitempty"`
+	KeepAliveSeconds             int  `json:"keepAliveSeconds,omitempty"`
+	NotRunningGraceChecks        int  `json:"notRunningGraceChecks,omitempty"`
+	WatchPod                     bool `json:"watchPod,omitempty"`
+	TolerateContainerRestarts    bool `json:"tolerateContainerRestarts,omitempty"`
+	WebSocket                    bool `json:"webSocket,omitempty"`
+	VerifyTargetPort             bool `json:"verifyTargetPort,omitempty"`
+	// The options of the request, kept as requested.
+	AllowSystemNamespace bool   `json:"allowSystemNamespace,omitempty"`
+	NoDelay              *bool  `json:"noDelay,omitempty"`
+	ReadBufferBytes      int    `json:"readBufferBytes,omitempty"`
+	WriteBufferBytes     int    `json:"writeBufferBytes,omitempty"`
+	Probe                string `json:"probe,omitempty"`
+	DisableMonitor       bool   `json:"disableMonitor,omitempty"`
+	PodNotReady          string `json:"podNotReady,omitempty"`
+}
+
+// exportPortForward returns the port forward as exported. The target ports
+// are the ones requested, by name if they were, and the pod is left out when
+// it was picked by the service, the pod selection or the workload targeted, to
+// be picked again.
+func exportPortForward(pf portForward) exportedPortForward {
+	e := exportedPortForward{
+		Name:                         pf.Name,
+		Cluster:                      pf.ClusterName,
+		Namespace:                    pf.Namespace,
+		Pod:                          pf.Pod,
+		Service:                      pf.Service,
+		ServiceNamespace:             pf.ServiceNamespace,
+		Container:                    pf.Container,
+		Addresses:                    pf.Addresses,
+		PodTemplateHash:              pf.PodTemplateHash,
+		PodAnnotationKey:             pf.PodAnnotationKey,
+		PodAnnotationValue:           pf.PodAnnotationValue,
+		CronJob:                      pf.CronJob,
+		LabelSelector:                pf.LabelSelector,
+		PodSelectionStrategy:         pf.PodSelectionStrategy,
+		AutoReconnect:                pf.AutoReconnect,
+		TargetKind:                   pf.TargetKind,
+		TargetName:                   pf.TargetName,
+		EntryTTLSeconds:              pf.EntryTTLSeconds,
+		ReusePort:                    pf.ReusePort,
+		ConnectionIdleTimeoutSeconds: pf.ConnectionIdleTimeoutSeconds,
+		MaxConnections:               pf.MaxConnections,
+		ReadinessTimeoutSeconds:      pf.ReadinessTimeoutSeconds,
+		IdleTimeoutSeconds:           pf.IdleTimeoutSeconds,
+		LivenessCheck:                pf.LivenessCheck,
+		KeepAliveSeconds:             pf.KeepAliveSeconds,
+		NotRunningGraceChecks:        pf.NotRunningGraceChecks,
+		WatchPod:                     pf.WatchPod,
+		TolerateContainerRestarts:    pf.TolerateContainerRestarts,
+		WebSocket:                    pf.WebSocket,
+	}
+
+	// The options only used when starting are the ones of the request. The
+	// local addresses are the ones requested rather than the ones they
+	// resolved to, the interface possibly having another address elsewhere.
+	if r := pf.request; r != nil {
+		e.VerifyTargetPort = r.VerifyTargetPort
+		e.AllowSystemNamespace = r.AllowSystemNamespace
+		e.NoDelay = r.NoDelay
+		e.ReadBufferBytes = r.ReadBufferBytes
+		e.WriteBufferBytes = r.WriteBufferBytes
+		e.Probe = r.Probe
+		e.DisableMonitor = r.DisableMonitor
+		e.PodNotReady = r.PodNotReady
+
+		if r.BindAddress != "" || r.Interface != "" {
+			e.Addresses = nil
+			e.BindAddress = r.BindAddress
+			e.Interface = r.Interface
+		}
+	}
+
+	pairs := []PortPair{}
+
+	for _, pair := range pf.portPairs() {
+		exported := PortPair{Port: pair.Port, TargetPort: pair.TargetPort}
+		if pair.TargetPortName != "" {
+			exported.TargetPort = pair.TargetPortName
+		}
+
+		// The WebSocket port forwards have no local ports.
+		if pf.WebSocket {
+			exported.Port = ""
+		}
+
+		pairs = append(pairs, exported)
+	}
+
+	if pf.WebSocket {
+		e.Addresses = nil
+	}
+
+	if pf.PodTemplateHash != "" || pf.PodAnnotationKey != "" || pf.CronJob != "" || pf.LabelSelector != "" ||
+		pf.TargetKind != "" {
+		e.Pod = ""
+	}
+
+	if r := pf.ServiceResolution; r != nil {
+		e.Pod = ""
+		e.ServicePort = r.ServicePort
+
+		if r.ServicePort == "" {
+			pairs[0].TargetPort = r.ServiceTargetPort
+		}
+	}
+
+	if len(pf.Ports) > 0 {
+		e.Ports = pairs
+
+		return e
+	}
+
+	e.Port = pairs[0].Port
+
+	if e.ServicePort == "" {
+		e.TargetPort = pairs[0].TargetPort
+	}
+
+	return e
+}
+
+// ExportPortForwards handles the export request, responding with the port
+// forwards of the cluster which aren't stopped as an array of
+// exportedPortForward, sorted as by GetPortForwards, which can be fed to
+// StartPortForwardsBulk to start them again, e.g. on another machine.
+func ExportPortForwards(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	cluster := r.URL.Query().Get("cluster")
+	if cluster == "" {
+		logger.Log(logger.LevelError, nil, errors.New("cluster is required"), "exporting portforwards")
+		http.Error(w, "cluster is required", http.StatusBadRequest)
+
+		return
+	}
+
+	ports, err := getPortForwardList(cache, userClusterName(r, cluster))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	exported := []exportedPortForward{}
+
+	for _, pf := range ports {
+		if pf.Status != STOPPED {
+			exported = append(exported, exportPortForward(pf))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(exported); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
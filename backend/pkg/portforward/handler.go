@@ -19,14 +19,22 @@ package portforward
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -34,10 +42,20 @@ import (
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/kubeconfig"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
 )
@@ -45,494 +63,3060 @@ import (
 const (
 	RUNNING = "Running"
 	STOPPED = "Stopped"
+	// RECONNECTING is the status of a port forward being retargeted to
+	// another pod, after losing its pod.
+	RECONNECTING = "Reconnecting"
+	// PAUSED is the status of a port forward stopped to free its local
+	// ports, which can be resumed on the same ones.
+	PAUSED = "Paused"
+)
+
+// StatusHeader is the response header carrying the port forward status
+// for HEAD requests on the get port forward by id route.
+const StatusHeader = "X-PortForward-Status"
+
+const (
+	// PodNotReadyWarn starts the port forward to a running pod which isn't
+	// ready, recording a warning on the port forward.
+	PodNotReadyWarn = "warn"
+	// PodNotReadyWait waits for the pod to become ready, up to the readiness
+	// timeout of the port forward, before starting it.
+	PodNotReadyWait = "wait"
 )
 
 const (
 	PodAvailabilityCheckTimer   = 5 // seconds
 	PortForwardReadinessTimeout = 30 * time.Second
+	// MaxReadinessTimeoutSeconds caps the readiness timeout of a port forward request.
+	MaxReadinessTimeoutSeconds = 300
+	// MaxDrainSeconds caps the drain of the port forwards of a stop request.
+	MaxDrainSeconds = 300
+	// apiRequestTimeout bounds the requests to the apiserver made to set up
+	// and monitor port forwards, so a wedged apiserver can't hang them.
+	apiRequestTimeout = 10 * time.Second
+	// podCheckJitter is the fraction the interval between the pod checks of a
+	// port forward randomly varies by, so the checks of many port forwards
+	// don't all hit the apiserver at once.
+	podCheckJitter = 0.2
 )
 
+// podCheckRandom returns the random number in [0, 1) picking the jitter of the
+// next pod check, replaced in tests.
+var podCheckRandom = rand.Float64
+
+// podCheckBaseInterval is the interval between the pod checks before jitter.
+var podCheckBaseInterval = PodAvailabilityCheckTimer * time.Second
+
+// podReadyPollInterval is the interval between the checks of a pod waited for to become ready.
+var podReadyPollInterval = time.Second
+
+// podCheckInterval returns the interval until the next pod check, within
+// podCheckJitter of podCheckBaseInterval.
+func podCheckInterval() time.Duration {
+	interval := podCheckBaseInterval
+
+	return interval + time.Duration(float64(interval)*podCheckJitter*(2*podCheckRandom()-1))
+}
+
+// PortPair is a local port forwarded to a port of the pod.
+type PortPair struct {
+	// Port is the local port, a free one is picked if empty.
+	Port string `json:"port"`
+	// TargetPort is the port of the pod, by number or container port name.
+	TargetPort string `json:"targetPort"`
+	// TargetPortName is the name of the container port TargetPort was
+	// resolved from, if requested by name.
+	TargetPortName string `json:"targetPortName,omitempty"`
+	// LocalAddress is the host:port address to connect to the local port at,
+	// set in the response once it is bound.
+	LocalAddress string `json:"localAddress,omitempty"`
+}
+
 type portForwardRequest struct {
-	ID               string `json:"id"`
-	Namespace        string `json:"namespace"`
-	Pod              string `json:"pod"`
+	// Name is shown to tell the port forward apart, at most MaxNameLength
+	// characters.
+	Name      string `json:"name,omitempty"`
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	// Service, when no pod is set, is port forwarded to through one of its
+	// pods, a ready one if any, with a TargetPort which may be the name of a
+	// port of the service or of a container port.
 	Service          string `json:"service"`
 	ServiceNamespace string `json:"serviceNamespace"`
 	TargetPort       string `json:"targetPort"`
 	Cluster          string `json:"cluster"`
 	Port             string `json:"port"`
+	// EntryTTLSeconds, when set, makes the cache entry expire this many
+	// seconds after the port forward stops.
+	EntryTTLSeconds int `json:"entryTTLSeconds,omitempty"`
+	// Container is the container of the pod the named target ports are
+	// container ports of, needed when the name is used by several containers.
+	Container string `json:"container,omitempty"`
+	// LocalAddress is the host:port address to connect to the port forward
+	// at, e.g. "127.0.0.1:8080", set in the response once it is bound. With
+	// Ports, it is the one of the first port, each port having its own.
+	LocalAddress string `json:"localAddress,omitempty"`
+	// Warning is why the port forward may not work as expected, e.g. its pod
+	// not being ready, set in the response.
+	Warning string `json:"warning,omitempty"`
+	// Addresses are the local addresses to listen on, "localhost" or IPs.
+	// Defaults to localhost when empty.
+	Addresses []string `json:"addresses,omitempty"`
+	// BindAddress, instead of Addresses, is the single IP address to listen
+	// on, e.g. "0.0.0.0" to expose the port forward on all the interfaces,
+	// and so to the other machines of the network.
+	BindAddress string `json:"bindAddress,omitempty"`
+	// Interface, instead of BindAddress or Addresses, is the name of the local
+	// network interface whose IPv4 address to listen on, e.g. "eth1" on a
+	// machine connected to several networks.
+	Interface string `json:"interface,omitempty"`
+	// AllowSystemNamespace opts in to port forwarding in one of the DeniedNamespaces.
+	AllowSystemNamespace bool `json:"allowSystemNamespace,omitempty"`
+	// ReusePort sets SO_REUSEPORT on the local listener. It lets other
+	// processes bind the same port and share its connections, so it's off by default.
+	ReusePort bool `json:"reusePort,omitempty"`
+	// DialHeaders are added to the upgrade request to the apiserver, for
+	// gateways in front of it routing on headers.
+	DialHeaders map[string]string `json:"dialHeaders,omitempty"`
+	// PodTemplateHash restricts the port forward to the pods of a ReplicaSet
+	// revision. The pod is then optional, and the port forward is retargeted
+	// to another pod of the revision when its pod goes away.
+	PodTemplateHash string `json:"podTemplateHash,omitempty"`
+	// PodAnnotationKey restricts the port forward to the pods with this
+	// annotation, with the value PodAnnotationValue if set. As with
+	// PodTemplateHash, the pod is then optional and the port forward is retargeted.
+	PodAnnotationKey   string `json:"podAnnotationKey,omitempty"`
+	PodAnnotationValue string `json:"podAnnotationValue,omitempty"`
+	// CronJob targets the pods of the latest Job of this CronJob instead of
+	// a pod, retargeting to the pods of the next Job once they are gone.
+	CronJob string `json:"cronJob,omitempty"`
+	// LabelSelector targets a ready pod matching this label selector, e.g.
+	// "app=web", instead of a pod. The pods are picked in turn unless
+	// PodSelectionStrategy is set, and the port forward is retargeted to
+	// another one when its pod goes away.
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// ServicePort is a port of the Service, by name or number, to port forward
+	// to. Its targetPort is resolved to the container port of a pod of the
+	// service, which is picked when no pod is set, so TargetPort isn't needed.
+	ServicePort string `json:"servicePort,omitempty"`
+	// ConnectionIdleTimeoutSeconds, when set, closes the local connections
+	// without traffic in either direction for this many seconds, which frees
+	// their stream to the pod.
+	ConnectionIdleTimeoutSeconds int `json:"connectionIdleTimeoutSeconds,omitempty"`
+	// MaxConnections, when set, is the most local connections proxied at once
+	// by the port forward, across its ports. The connections over it are
+	// refused until others are closed, so a burst of connections doesn't
+	// overload the pod.
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// IdleTimeoutSeconds, when set, stops the port forward once no connection
+	// was made nor data forwarded in either direction for this many seconds,
+	// so forgotten port forwards don't keep their connection to the apiserver.
+	IdleTimeoutSeconds int `json:"idleTimeoutSeconds,omitempty"`
+	// LivenessCheck, when set, periodically checks the local ports still
+	// accept connections, stopping the port forward once they failed to
+	// livenessFailureThreshold checks in a row.
+	LivenessCheck bool `json:"livenessCheck,omitempty"`
+	// KeepAliveSeconds, when set, nudges the tunnel this often by opening a
+	// connection through it and closing it right away, so the SPDY connection
+	// isn't dropped by load balancers with short idle timeouts.
+	KeepAliveSeconds int `json:"keepAliveSeconds,omitempty"`
+	// NotRunningGraceChecks, when set, tolerates the pod not running for this
+	// many pod checks in a row, e.g. while briefly Pending, before it's
+	// considered lost. The count is reset once the pod is running again.
+	NotRunningGraceChecks int `json:"notRunningGraceChecks,omitempty"`
+	// WatchPod watches the pod instead of checking it periodically, so the
+	// port forward reacts as soon as the pod is deleted or fails. The pod is
+	// checked periodically if it can't be watched.
+	WatchPod bool `json:"watchPod,omitempty"`
+	// TolerateContainerRestarts keeps the port forward while its pod isn't
+	// running because its containers restart in place, the pod not being
+	// recreated, waiting for it to run again rather than considering it lost.
+	TolerateContainerRestarts bool `json:"tolerateContainerRestarts,omitempty"`
+	// ReadinessTimeoutSeconds, when set, is how long to wait for the port
+	// forward to become ready, instead of PortForwardReadinessTimeout, e.g.
+	// for slow clusters or links. It's at most MaxReadinessTimeoutSeconds.
+	ReadinessTimeoutSeconds int `json:"readinessTimeoutSeconds,omitempty"`
+	// PodNotReady is what to do when the pod is running but not ready, e.g.
+	// failing its readiness probe, so it may not serve the port forward yet:
+	// one of PodNotReadyWarn, the default, or PodNotReadyWait.
+	PodNotReady string `json:"podNotReady,omitempty"`
+	// NoDelay sets TCP_NODELAY on the local connections, on by default. It
+	// lowers the latency of interactive protocols sending small messages,
+	// while turning it off lets the kernel coalesce them, saving packets for
+	// chatty bulk transfers.
+	NoDelay *bool `json:"noDelay,omitempty"`
+	// ReadBufferBytes and WriteBufferBytes set the socket buffer sizes of the
+	// local connections, between 4 KiB and 16 MiB. Larger buffers help the
+	// throughput of bulk transfers at the cost of memory per connection and of
+	// the latency of data queued behind them. The system defaults are kept if unset.
+	ReadBufferBytes  int `json:"readBufferBytes,omitempty"`
+	WriteBufferBytes int `json:"writeBufferBytes,omitempty"`
+	// PodSelectionStrategy is how the pod is picked among the ones matching
+	// the pod selection, when no pod is set and on retargets: one of
+	// PodSelectionReadyFirst, the default, PodSelectionNewest,
+	// PodSelectionOldest, PodSelectionRandom or PodSelectionRoundRobin, the
+	// default with a LabelSelector.
+	PodSelectionStrategy string `json:"podSelectionStrategy,omitempty"`
+	// AutoReconnect, for a pod without pod selection, reconnects the port
+	// forward to a ready pod of the Deployment, StatefulSet, DaemonSet or
+	// ReplicaSet controlling the pod once the pod is gone, e.g. after a
+	// rollout, keeping its id and local port.
+	AutoReconnect bool `json:"autoReconnect,omitempty"`
+	// TargetKind and TargetName, instead of a pod, target a ready pod of a
+	// workload, e.g. Deployment and web, the kind being one of Deployment,
+	// ReplicaSet, StatefulSet or DaemonSet. The pod is picked from the pods
+	// of its selector, and with AutoReconnect, another ready one is picked
+	// once it's gone, e.g. after a rollout.
+	TargetKind string `json:"targetKind,omitempty"`
+	TargetName string `json:"targetName,omitempty"`
+	// DryRun only checks the port forward could be started: its target is
+	// resolved, its pod is running, the user is allowed to port forward to it
+	// and its local ports are available. Nothing is started.
+	DryRun bool `json:"dryRun,omitempty"`
+	// WebSocket bridges the port forward to the WebSocket connections of the
+	// port forward WebSocket handler instead of listening on local ports,
+	// for the clients which can't connect to them, e.g. sandboxed browsers.
+	WebSocket bool `json:"webSocket,omitempty"`
+	// VerifyTargetPort checks the target ports are container ports the pod
+	// declares, of the Container if set, before opening the tunnel, failing
+	// with the ports it declares otherwise. A mistyped port would otherwise
+	// have each local connection reset, nothing listening on it.
+	VerifyTargetPort bool `json:"verifyTargetPort,omitempty"`
+	// ForceNew starts a new port forward even if one to the same pod and
+	// target ports, and local ports if set, is running already, which
+	// StartPortForward otherwise returns instead, e.g. for parallel tunnels.
+	ForceNew bool `json:"forceNew,omitempty"`
+	// DisableMonitor starts the port forward without its pod monitor checking
+	// the pod is running, so losing the pod doesn't stop or retarget it.
+	DisableMonitor bool `json:"disableMonitor,omitempty"`
+	// Probe is run once the port forward is running, to tell what answers on
+	// it: one of ProbeBanner, ProbePostgres or ProbeRedis.
+	Probe string `json:"probe,omitempty"`
+	// DependsOn are the ids of earlier port forwards of a batch start which
+	// must be running before this one is started.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Ports, instead of Port and TargetPort, forwards several ports of the
+	// pod under the one port forward, so they are started, retargeted and
+	// stopped together. With a service, the first target port is resolved as
+	// TargetPort is, and the others are container ports of the picked pod.
+	Ports []PortPair `json:"ports,omitempty"`
+	// interfaceAddress is the address of Interface, resolved when validating.
+	interfaceAddress string
+	// requestID correlates the log lines of the port forward, taken from the
+	// X-Request-ID header of the request starting it, if set.
+	requestID string
+	// clusterKey is the name the cluster of the port forward is stored under,
+	// Cluster with the X-HEADLAMP-USER-ID appended, if any.
+	clusterKey string
+	// reuseRunning returns the running port forward the request duplicates,
+	// if any, instead of starting a new one, once the request is validated.
+	reuseRunning bool
 }
 
 func (p *portForwardRequest) Validate() error {
 	if p.Namespace == "" {
-		return fmt.Errorf("namespace is required")
+		return newError(ErrCodeInvalidRequest, nil, "namespace is required")
 	}
 
-	if p.Pod == "" {
-		return fmt.Errorf("pod name is required")
+	if err := validateName(p.Name); err != nil {
+		return err
 	}
 
-	if p.TargetPort == "" {
-		return fmt.Errorf("targetPort is required")
+	if p.ServicePort != "" && p.Service == "" {
+		return newError(ErrCodeInvalidRequest, nil, "servicePort requires service")
 	}
 
-	if p.Cluster == "" {
-		return fmt.Errorf("cluster name is required")
+	if p.Pod == "" && p.Service == "" && p.PodTemplateHash == "" && p.PodAnnotationKey == "" && p.CronJob == "" &&
+		p.LabelSelector == "" && p.TargetName == "" {
+		return newError(ErrCodeInvalidRequest, nil, "pod name is required")
 	}
 
-	return nil
-}
+	if err := p.validateTargetWorkload(); err != nil {
+		return err
+	}
 
-type portForward struct {
-	ID               string `json:"id"`
-	closeChan        chan struct{}
-	Pod              string `json:"pod"`
-	Service          string `json:"service"`
-	ServiceNamespace string `json:"serviceNamespace"`
-	Namespace        string `json:"namespace"`
-	Cluster          string `json:"cluster"`
-	Port             string `json:"port"`
-	TargetPort       string `json:"targetPort"`
-	Status           string `json:"status"`
-	Error            string `json:"error"`
-}
+	if p.LabelSelector != "" {
+		if _, err := labels.Parse(p.LabelSelector); err != nil {
+			return newError(ErrCodeInvalidRequest, err, "invalid labelSelector %q", p.LabelSelector)
+		}
+	}
 
-func getFreePort() (int, error) {
-	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
-	if err != nil {
-		return 0, err
+	if p.CronJob != "" {
+		if p.Pod != "" {
+			return newError(ErrCodeInvalidRequest, nil, "pod and cronJob can't both be set")
+		}
+
+		if errs := validation.IsDNS1123Subdomain(p.CronJob); len(errs) > 0 {
+			return newError(ErrCodeInvalidRequest, nil, "invalid cronJob %q: %s", p.CronJob, strings.Join(errs, ", "))
+		}
 	}
 
-	l, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		return 0, err
+	if p.Container != "" {
+		if errs := validation.IsDNS1123Label(p.Container); len(errs) > 0 {
+			return newError(ErrCodeInvalidRequest, nil, "invalid container %q: %s", p.Container, strings.Join(errs, ", "))
+		}
 	}
 
-	defer l.Close()
+	if p.PodTemplateHash != "" {
+		if errs := validation.IsValidLabelValue(p.PodTemplateHash); len(errs) > 0 {
+			return newError(ErrCodeInvalidRequest, nil, "invalid podTemplateHash %q: %s", p.PodTemplateHash,
+				strings.Join(errs, ", "))
+		}
+	}
 
-	return l.Addr().(*net.TCPAddr).Port, nil
-}
+	if err := p.validatePodAnnotation(); err != nil {
+		return err
+	}
 
-// StartPortForward handles the port forward request.
-//
-//nolint:funlen
-func StartPortForward(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}],
-	w http.ResponseWriter, r *http.Request,
-) {
-	var p portForwardRequest
+	if err := p.validatePorts(); err != nil {
+		return err
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		logger.Log(logger.LevelError, nil, err, "decoding portforward payload")
-		http.Error(w, "failed to marshal port forward payload "+err.Error(), http.StatusBadRequest)
+	if p.Cluster == "" {
+		return newError(ErrCodeInvalidRequest, nil, "cluster name is required")
+	}
 
-		return
+	if p.EntryTTLSeconds < 0 {
+		return newError(ErrCodeInvalidRequest, nil, "entryTTLSeconds must not be negative")
 	}
 
-	if p.ID == "" {
-		p.ID = uuid.New().String()
+	if p.ConnectionIdleTimeoutSeconds < 0 {
+		return newError(ErrCodeInvalidRequest, nil, "connectionIdleTimeoutSeconds must not be negative")
 	}
 
-	reqToken := r.Header.Get("Authorization")
-	splitToken := strings.Split(reqToken, "Bearer ")
+	if p.MaxConnections < 0 {
+		return newError(ErrCodeInvalidRequest, nil, "maxConnections must not be negative")
+	}
 
-	var token string
-	if reqToken != "" && len(splitToken) >= 2 {
-		token = splitToken[1]
+	if p.IdleTimeoutSeconds < 0 {
+		return newError(ErrCodeInvalidRequest, nil, "idleTimeoutSeconds must not be negative")
 	}
 
-	if err := p.Validate(); err != nil {
-		logger.Log(logger.LevelError, nil, err, "validating portforward payload")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if p.KeepAliveSeconds < 0 {
+		return newError(ErrCodeInvalidRequest, nil, "keepAliveSeconds must not be negative")
+	}
 
-		return
+	if p.NotRunningGraceChecks < 0 {
+		return newError(ErrCodeInvalidRequest, nil, "notRunningGraceChecks must not be negative")
 	}
 
-	if p.Port == "" {
-		freePort, err := getFreePort()
-		if err != nil || freePort == 0 {
-			logger.Log(logger.LevelError, nil, err, "getting free port")
-			http.Error(w, "can't find any available port "+err.Error(), http.StatusInternalServerError)
+	if p.ReadinessTimeoutSeconds < 0 || p.ReadinessTimeoutSeconds > MaxReadinessTimeoutSeconds {
+		return newError(ErrCodeInvalidRequest, nil, "readinessTimeoutSeconds must be between 0 (default) and %d",
+			MaxReadinessTimeoutSeconds)
+	}
 
-			return
-		}
+	if err := validateSocketBuffer("readBufferBytes", p.ReadBufferBytes); err != nil {
+		return err
+	}
 
-		p.Port = strconv.Itoa(freePort)
+	if err := validateSocketBuffer("writeBufferBytes", p.WriteBufferBytes); err != nil {
+		return err
 	}
 
-	userID := r.Header.Get("X-HEADLAMP-USER-ID")
-	clusterName := p.Cluster
+	if p.BindAddress != "" {
+		if len(p.Addresses) > 0 {
+			return newError(ErrCodeInvalidRequest, nil, "bindAddress and addresses can't both be set")
+		}
 
-	if userID != "" {
-		clusterName = p.Cluster + userID
+		if net.ParseIP(p.BindAddress) == nil {
+			return newError(ErrCodeInvalidRequest, nil, "invalid bindAddress %q, must be an IP address", p.BindAddress)
+		}
 	}
 
-	kContext, err := kubeConfigStore.GetContext(clusterName)
-	if err != nil {
-		logger.Log(logger.LevelError, map[string]string{"cluster": p.Cluster},
-			err, "getting kubeconfig context")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-
-		return
+	for _, address := range p.Addresses {
+		if address != "localhost" && net.ParseIP(address) == nil {
+			return newError(ErrCodeInvalidRequest, nil, "invalid address %q, must be localhost or an IP address", address)
+		}
 	}
 
-	err = startPortForward(kContext, cache, p, token)
-	if err != nil {
-		logger.Log(logger.LevelError, nil, err, "starting portforward")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if p.Interface != "" {
+		if p.BindAddress != "" || len(p.Addresses) > 0 {
+			return newError(ErrCodeInvalidRequest, nil, "interface can't be set along with bindAddress or addresses")
+		}
 
-		return
-	}
+		address, err := interfaceAddress(p.Interface)
+		if err != nil {
+			return err
+		}
 
-	w.Header().Set("Content-Type", "application/json")
+		p.interfaceAddress = address
+	}
 
-	if err = json.NewEncoder(w).Encode(p); err != nil {
-		logger.Log(logger.LevelError, nil, err, "writing json payload to response write")
-		http.Error(w, "failed to write json payload to response write "+err.Error(), http.StatusInternalServerError)
+	if p.ReusePort && !reusePortSupported {
+		return newError(ErrCodeInvalidRequest, nil, "reusePort is not supported on this platform")
+	}
 
-		return
+	if err := validateDialHeaders(p.DialHeaders); err != nil {
+		return err
 	}
-}
 
-// getKubeClientAndConfig prepares Kubernetes clientset and REST config.
-// It takes a kubeconfig context and an optional bearer token.
-// It returns the configured clientset, REST config, or an error if setup fails.
-func getKubeClientAndConfig(kContext *kubeconfig.Context, token string) (*kubernetes.Clientset, *rest.Config, error) {
-	clientset, err := kContext.ClientSetWithToken(token)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create clientset: %w", err)
+	if p.PodSelectionStrategy != "" && !isValidPodSelectionStrategy(p.PodSelectionStrategy) {
+		return newError(ErrCodeInvalidRequest, nil, "unknown podSelectionStrategy %q, must be one of %s, %s, %s, %s or %s",
+			p.PodSelectionStrategy, PodSelectionReadyFirst, PodSelectionNewest, PodSelectionOldest, PodSelectionRandom,
+			PodSelectionRoundRobin)
 	}
 
-	rConf, err := kContext.RESTConfig()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get REST config: %w", err)
+	if p.PodNotReady != "" && p.PodNotReady != PodNotReadyWarn && p.PodNotReady != PodNotReadyWait {
+		return newError(ErrCodeInvalidRequest, nil, "unknown podNotReady %q, must be %s or %s",
+			p.PodNotReady, PodNotReadyWarn, PodNotReadyWait)
 	}
 
-	if token != "" {
-		rConf.BearerToken = token
+	if p.Probe != "" && !isValidProbe(p.Probe) {
+		return newError(ErrCodeInvalidRequest, nil, "unknown probe %q, must be one of %s, %s or %s",
+			p.Probe, ProbeBanner, ProbePostgres, ProbeRedis)
 	}
 
-	return clientset, rConf, nil
+	return p.validateWebSocket()
 }
 
-// initPortForwarder sets up the SPDY dialer and creates a new port forwarder.
-// It requires a REST config, namespace, pod name, and the port mapping string (e.g., "8080:80").
-// It returns the port forwarder instance, stop/ready channels, output/error buffers, or an error.
-func initPortForwarder(rConf *rest.Config, namespace, podName, portMapping string) (
-	*portforward.PortForwarder, chan struct{}, chan struct{}, *bytes.Buffer, *bytes.Buffer, error,
-) {
-	roundTripper, upgrader, err := spdy.RoundTripperFor(rConf)
-	if err != nil {
-		return nil, nil, nil, nil, nil, fmt.Errorf("failed to create SPDY round tripper: %w", err)
+// validateTargetWorkload checks the workload targeted, if any, is of a
+// supported kind and is the only target of the port forward.
+func (p *portForwardRequest) validateTargetWorkload() error {
+	if p.TargetKind == "" && p.TargetName == "" {
+		return nil
 	}
 
-	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", namespace, podName)
-
-	hostURL, err := url.Parse(rConf.Host)
-	if err != nil {
-		return nil, nil, nil, nil, nil, fmt.Errorf("invalid REST config host: %w", err)
+	if p.TargetKind == "" || p.TargetName == "" {
+		return newError(ErrCodeInvalidRequest, nil, "targetKind and targetName must be set together")
 	}
 
-	fullURL := hostURL.ResolveReference(&url.URL{Path: path})
+	if !slices.Contains(workloadKinds, p.TargetKind) {
+		return newError(ErrCodeInvalidRequest, nil, "unsupported targetKind %q, must be one of %s",
+			p.TargetKind, strings.Join(workloadKinds, ", "))
+	}
 
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, fullURL)
-	stopChan, readyChan := make(chan struct{}), make(chan struct{}, 1)
-	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	if errs := validation.IsDNS1123Subdomain(p.TargetName); len(errs) > 0 {
+		return newError(ErrCodeInvalidRequest, nil, "invalid targetName %q: %s", p.TargetName, strings.Join(errs, ", "))
+	}
 
-	forwarder, err := portforward.New(dialer, []string{portMapping}, stopChan, readyChan, out, errOut)
-	if err != nil {
-		return nil, nil, nil, nil, nil, fmt.Errorf("failed to create portforwarder: %w", err)
+	if p.Pod != "" || p.Service != "" || p.PodTemplateHash != "" || p.PodAnnotationKey != "" || p.CronJob != "" ||
+		p.LabelSelector != "" {
+		return newError(ErrCodeInvalidRequest, nil,
+			"targetKind can't be set along with pod, service, podTemplateHash, podAnnotationKey, cronJob or labelSelector")
 	}
 
-	return forwarder, stopChan, readyChan, out, errOut, nil
+	return nil
 }
 
-// safeCloseChan attempts to close a channel and recovers from a panic
-// if the channel is already closed or nil.
-func safeCloseChan(ch chan struct{}) {
-	defer func() {
-		if r := recover(); r != nil {
-			_ = r
+// validateWebSocket checks a WebSocket port forward has none of the options
+// of the local ports.
+func (p *portForwardRequest) validateWebSocket() error {
+	if !p.WebSocket {
+		return nil
+	}
+
+	for _, pair := range p.portPairs() {
+		if pair.Port != "" {
+			return newError(ErrCodeInvalidRequest, nil, "webSocket and local port %s can't both be set", pair.Port)
 		}
-	}()
+	}
 
-	if ch != nil {
-		close(ch)
+	if p.BindAddress != "" || len(p.Addresses) > 0 || p.Interface != "" || p.ReusePort || p.LivenessCheck {
+		return newError(ErrCodeInvalidRequest, nil,
+			"webSocket can't be set along with bindAddress, addresses, interface, reusePort or livenessCheck")
 	}
+
+	return nil
 }
 
-// monitorPodAndManagePortForward runs in a goroutine and periodically checks if the
-// target pod for a port-forward is still running. If the pod is not running
-// (or if an unrecoverable error occurs during check), it signals the port-forward
-// to stop by closing its stopChan and updates its status in the cache.
-// It stops when the associated port-forward's closeChan is closed.
-func monitorPodAndManagePortForward(
-	clientset *kubernetes.Clientset,
-	cache cache.Cache[interface{}],
-	pfDetails *portForward,
-) {
-	ticker := time.NewTicker(PodAvailabilityCheckTimer * time.Second)
-	defer ticker.Stop()
+// normalizePort checks the port of the field is a number between 1 and 65535,
+// or a port name if allowed, and returns it without leading zeros or spaces.
+func normalizePort(field, port string, allowName bool) (string, error) {
+	port = strings.TrimSpace(port)
 
-	logParams := map[string]string{"id": pfDetails.ID, "pod": pfDetails.Pod, "namespace": pfDetails.Namespace}
+	number, err := strconv.Atoi(port)
+	if err != nil {
+		if allowName && len(validation.IsValidPortName(port)) == 0 {
+			return port, nil
+		}
 
-	for {
-		select {
-		case <-ticker.C:
-			err := checkIfPodIsRunning(clientset, pfDetails.Namespace, pfDetails.Pod)
-			if err != nil {
-				if errors.Is(err, syscall.ECONNREFUSED) {
-					logger.Log(logger.LevelInfo, logParams, err, "checking pod (ECONNREFUSED), continuing")
-					continue
-				}
+		if allowName {
+			return "", newError(ErrCodeInvalidRequest, nil,
+				"invalid %s %q, must be a number between 1 and 65535 or a port name", field, port)
+		}
 
-				errMsg := fmt.Sprintf("Pod %s/%s check failed: %v", pfDetails.Namespace, pfDetails.Pod, err)
-				logger.Log(logger.LevelError, logParams, errors.New(errMsg), "stopping port-forward due to pod status")
+		return "", newError(ErrCodeInvalidRequest, nil, "invalid %s %q, must be a number between 1 and 65535", field, port)
+	}
 
-				pfDetails.Status = STOPPED
-				pfDetails.Error = errMsg
-				portforwardstore(cache, *pfDetails)
-				safeCloseChan(pfDetails.closeChan)
+	if number < 1 || number > 65535 {
+		return "", newError(ErrCodeInvalidRequest, nil, "%s %d is out of range, must be between 1 and 65535", field, number)
+	}
 
-				return
-			}
-		case <-pfDetails.closeChan:
-			logger.Log(logger.LevelInfo, logParams, nil, "Pod monitor stopping: port forward closeChan was closed.")
+	return strconv.Itoa(number), nil
+}
 
-			return
+// normalizePortPair normalizes the local port, if set, and the target port of
+// the pair, which may be the name of a container port.
+func normalizePortPair(prefix string, pair *PortPair) error {
+	var err error
+
+	if pair.Port != "" {
+		if pair.Port, err = normalizePort(prefix+"port", pair.Port, false); err != nil {
+			return err
 		}
 	}
-}
-
-// handlePortForwardReadiness waits for the port forward to be ready, handling potential
-// errors from errOut, timeouts, or premature stop signals.
-// It updates the portForward details in the cache based on the outcome.
-func handlePortForwardReadiness(
-	cache cache.Cache[interface{}],
-	pfDetails *portForward,
-	readyChan chan struct{},
-	errOut *bytes.Buffer,
-	logParams map[string]string,
-) error {
-	select {
-	case <-readyChan:
-		if errOut.String() != "" {
-			errMsg := fmt.Sprintf("portforward failed to start, stderr: %s", errOut.String())
-			logger.Log(logger.LevelError, logParams, errors.New(errMsg), "checking ready status")
 
-			pfDetails.Status = STOPPED
-			pfDetails.Error = errMsg
+	if pair.TargetPort != "" {
+		if pair.TargetPort, err = normalizePort(prefix+"targetPort", pair.TargetPort, true); err != nil {
+			return err
+		}
+	}
 
-			portforwardstore(cache, *pfDetails)
-			safeCloseChan(pfDetails.closeChan)
+	return nil
+}
 
-			return errors.New(errMsg)
+// validatePorts checks the port to forward, or the port pairs if set, and
+// normalizes the port numbers.
+func (p *portForwardRequest) validatePorts() error {
+	if len(p.Ports) == 0 {
+		if p.TargetPort == "" && p.ServicePort == "" {
+			return newError(ErrCodeInvalidRequest, nil, "targetPort is required")
 		}
 
-		pfDetails.Status = RUNNING
-		pfDetails.Error = ""
+		pair := PortPair{Port: p.Port, TargetPort: p.TargetPort}
+		if err := normalizePortPair("", &pair); err != nil {
+			return err
+		}
 
-		portforwardstore(cache, *pfDetails)
-		logger.Log(logger.LevelInfo, logParams, nil, "Port forward ready and running.")
+		p.Port, p.TargetPort = pair.Port, pair.TargetPort
 
-	case <-time.After(PortForwardReadinessTimeout):
-		errMsg := "timeout waiting for portforward to become ready"
-		logger.Log(logger.LevelError, logParams, errors.New(errMsg), "readiness timeout")
+		return nil
+	}
 
-		pfDetails.Status = STOPPED
-		pfDetails.Error = errMsg
+	if p.Port != "" || p.TargetPort != "" || p.ServicePort != "" {
+		return newError(ErrCodeInvalidRequest, nil, "ports can't be set with port, targetPort or servicePort")
+	}
 
-		portforwardstore(cache, *pfDetails)
-		safeCloseChan(pfDetails.closeChan)
+	ports := map[string]int{}
 
-		return errors.New(errMsg)
+	for i := range p.Ports {
+		pair := &p.Ports[i]
 
-	case <-pfDetails.closeChan:
-		errMsg := "portforward stopped before becoming ready"
-		logger.Log(logger.LevelInfo, logParams, nil, errMsg)
+		if pair.TargetPort == "" {
+			return newError(ErrCodeInvalidRequest, nil, "ports[%d].targetPort is required", i)
+		}
 
-		if pfDetails.Status == RUNNING {
-			pfDetails.Status = STOPPED
+		if err := normalizePortPair(fmt.Sprintf("ports[%d].", i), pair); err != nil {
+			return err
 		}
 
-		if pfDetails.Error == "" {
-			pfDetails.Error = errMsg
+		if pair.Port == "" {
+			continue
 		}
 
-		portforwardstore(cache, *pfDetails)
+		if first, ok := ports[pair.Port]; ok {
+			return newError(ErrCodeInvalidRequest, nil, "ports[%d].port %s is also used by ports[%d]",
+				i, pair.Port, first)
+		}
 
-		return errors.New(errMsg)
+		ports[pair.Port] = i
 	}
 
 	return nil
 }
 
-// runAndMonitorPortForward starts the actual port forwarding in a goroutine,
-// then handles its readiness, and if ready, starts another goroutine to
-// monitor the target pod's status.
-func runAndMonitorPortForward(
-	clientset *kubernetes.Clientset,
-	cache cache.Cache[interface{}],
-	pfDetails *portForward,
-	forwarder *portforward.PortForwarder,
-	readyChan chan struct{},
-	errOut *bytes.Buffer,
-) error {
-	logParams := map[string]string{
-		"id": pfDetails.ID, "pod": pfDetails.Pod, "port": pfDetails.Port, "targetPort": pfDetails.TargetPort,
+// portPairs returns the port pairs to forward, the one of Port and TargetPort
+// if Ports isn't set.
+func (p *portForwardRequest) portPairs() []PortPair {
+	if len(p.Ports) == 0 {
+		return []PortPair{{Port: p.Port, TargetPort: p.TargetPort}}
 	}
 
-	go func() {
-		if err := forwarder.ForwardPorts(); err != nil {
-			logger.Log(logger.LevelError, logParams, err, "ForwardPorts() failed")
+	return append([]PortPair{}, p.Ports...)
+}
 
-			pfDetails.Status = STOPPED
-			pfDetails.Error = err.Error()
+// localAddresses returns the local addresses to listen on, localhost if empty.
+func (p *portForwardRequest) localAddresses() []string {
+	if p.interfaceAddress != "" {
+		return []string{p.interfaceAddress}
+	}
 
-			portforwardstore(cache, *pfDetails)
-			safeCloseChan(pfDetails.closeChan)
-		} else {
-			logger.Log(logger.LevelInfo, logParams, nil, "ForwardPorts() exited.")
+	if p.BindAddress != "" {
+		return []string{p.BindAddress}
+	}
 
-			if pfDetails.Status == RUNNING {
-				pfDetails.Status = STOPPED
-				if pfDetails.Error == "" {
-					pfDetails.Error = "Port forward stopped."
-				}
+	return p.Addresses
+}
 
-				portforwardstore(cache, *pfDetails)
-			}
-		}
-	}()
+// setBound updates the request with the local addresses and ports the port
+// forward is bound to, the latter being picked when the request has none, and
+// with its warning.
+func (p *portForwardRequest) setBound(pf portForward) {
+	p.Addresses = pf.Addresses
+	p.Warning = pf.Warning
+	p.setPorts(pf)
+
+	p.LocalAddress = localAddress(pf.Addresses, pf.Port)
+	for i := range p.Ports {
+		p.Ports[i].LocalAddress = localAddress(pf.Addresses, p.Ports[i].Port)
+	}
+}
 
-	err := handlePortForwardReadiness(cache, pfDetails, readyChan, errOut, logParams)
-	if err != nil {
-		return err
+// localAddress returns the host:port address of the local port on the first
+// of the addresses it is bound to, or "" if it isn't bound.
+func localAddress(addresses []string, port string) string {
+	if len(addresses) == 0 || port == "" {
+		return ""
 	}
 
-	go monitorPodAndManagePortForward(clientset, cache, pfDetails)
+	return net.JoinHostPort(addresses[0], port)
+}
 
-	return nil
+// setPorts sets the local ports of the request to the ones of the port forward.
+func (p *portForwardRequest) setPorts(pf portForward) {
+	if len(p.Ports) == 0 {
+		p.Port = pf.Port
+
+		return
+	}
+
+	for i, pair := range pf.portPairs() {
+		p.Ports[i].Port = pair.Port
+	}
 }
 
-// startPortForward starts a port forward. This is the internal function that was refactored.
-// It sets up Kubernetes clients, initializes the port forwarder, and manages its lifecycle.
-func startPortForward(kContext *kubeconfig.Context, cache cache.Cache[interface{}],
-	p portForwardRequest, token string,
-) error {
-	clientset, rConf, err := getKubeClientAndConfig(kContext, token)
-	if err != nil {
-		return fmt.Errorf("failed to setup Kubernetes client/config: %w", err)
+// validateSocketBuffer checks a socket buffer size is unset or in the allowed range.
+func validateSocketBuffer(name string, size int) error {
+	if size != 0 && (size < minSocketBufferBytes || size > maxSocketBufferBytes) {
+		return newError(ErrCodeInvalidRequest, nil, "%s must be between %d and %d", name,
+			minSocketBufferBytes, maxSocketBufferBytes)
 	}
 
-	portMapping := p.Port + ":" + p.TargetPort
+	return nil
+}
 
-	var (
-		forwarder           *portforward.PortForwarder
-		stopChan, readyChan chan struct{}
-		outBuffer, errOut   *bytes.Buffer
-		errInit             error
-	)
+// checkBindPolicy refuses the local addresses other than the loopback ones,
+// unless AllowNonLoopbackBind. It is checked once the request is validated,
+// its Interface being resolved.
+func (p *portForwardRequest) checkBindPolicy() error {
+	if AllowNonLoopbackBind {
+		return nil
+	}
 
-	forwarder, stopChan, readyChan, outBuffer, errOut, errInit = initPortForwarder(
-		rConf, p.Namespace, p.Pod, portMapping,
-	)
-	if errInit != nil {
-		return fmt.Errorf("failed to initialize port forwarder: %w", errInit)
+	for _, address := range p.localAddresses() {
+		if !isLoopbackAddress(address) {
+			return newError(ErrCodeForbidden, nil, "listening on the non-loopback address %s is denied by the "+
+				"portforward-allow-non-loopback-bind policy, only loopback addresses are allowed", address)
+		}
 	}
 
-	_ = outBuffer // Avoid unused variable error if outBuffer isn't used directly later
+	return nil
+}
 
-	pfDetails := &portForward{
-		ID:               p.ID,
-		closeChan:        stopChan,
-		Pod:              p.Pod,
-		Cluster:          p.Cluster,
-		Namespace:        p.Namespace,
-		Service:          p.Service,
-		ServiceNamespace: p.ServiceNamespace,
-		TargetPort:       p.TargetPort,
-		Status:           RUNNING,
-		Port:             p.Port,
-		Error:            "",
+// storedCluster returns the name the cluster of the port forward is stored
+// under, its clusterKey if set.
+func (p *portForwardRequest) storedCluster() string {
+	if p.clusterKey != "" {
+		return p.clusterKey
 	}
 
-	return runAndMonitorPortForward(clientset, cache, pfDetails, forwarder, readyChan, errOut)
+	return p.Cluster
 }
 
-func checkIfPodIsRunning(clientset *kubernetes.Clientset, namespace string, pod string) error {
-	ctx := context.Background()
+// socketOptions returns the socket options of the local connections, with the defaults applied.
+func (p *portForwardRequest) socketOptions() socketOptions {
+	return socketOptions{
+		NoDelay:          p.NoDelay == nil || *p.NoDelay,
+		ReadBufferBytes:  p.ReadBufferBytes,
+		WriteBufferBytes: p.WriteBufferBytes,
+	}
+}
 
-	p, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod, v1.GetOptions{})
-	if err != nil {
-		return err
+// validatePodAnnotation checks the annotation selecting the pods, if any.
+func (p *portForwardRequest) validatePodAnnotation() error {
+	if p.PodAnnotationKey == "" {
+		if p.PodAnnotationValue != "" {
+			return newError(ErrCodeInvalidRequest, nil, "podAnnotationValue requires podAnnotationKey")
+		}
+
+		return nil
 	}
 
-	if p.Status.Phase != corev1.PodRunning {
-		return errors.New("pod is not running")
+	// Annotation keys are validated as lowercased qualified names, as the apiserver does.
+	if errs := validation.IsQualifiedName(strings.ToLower(p.PodAnnotationKey)); len(errs) > 0 {
+		return newError(ErrCodeInvalidRequest, nil, "invalid podAnnotationKey %q: %s", p.PodAnnotationKey,
+			strings.Join(errs, ", "))
 	}
 
 	return nil
 }
 
-// stopOrDeletePortForwardRequest is the payload for stop or delete port forward request handler.
-type stopOrDeletePortForwardRequest struct {
-	ID           string `json:"id"`
-	Cluster      string `json:"cluster"`
-	StopOrDelete bool   `json:"stopOrDelete"`
+type portForward struct {
+	ID string `json:"id"`
+	// Name is the name shown for the port forward, if any.
+	Name             string `json:"name,omitempty"`
+	closeChan        chan struct{}
+	Pod              string `json:"pod"`
+	Service          string `json:"service"`
+	ServiceNamespace string `json:"serviceNamespace"`
+	Namespace        string `json:"namespace"`
+	// Cluster is the name the cluster is stored under, with the
+	// X-HEADLAMP-USER-ID appended for dynamically configured clusters.
+	Cluster string `json:"-"`
+	// ClusterName is the name of the cluster the user knows, without the user id.
+	ClusterName     string `json:"cluster"`
+	Port            string `json:"port"`
+	TargetPort      string `json:"targetPort"`
+	Status          string `json:"status"`
+	Error           string `json:"error"`
+	EntryTTLSeconds int    `json:"entryTTLSeconds,omitempty"`
+	// TargetPortName is the name of the container port TargetPort was
+	// resolved from, if requested by name.
+	TargetPortName string `json:"targetPortName,omitempty"`
+	// RequestID correlates the log lines of the port forward across its lifecycle.
+	RequestID string `json:"requestId,omitempty"`
+	// ServerURL is the URL of the API server the port forward goes through,
+	// without credentials.
+	ServerURL string `json:"serverURL,omitempty"`
+	// Container is the container the named target ports are resolved in, if set.
+	Container string `json:"container,omitempty"`
+	// Addresses are the local addresses the port forward is bound to.
+	Addresses []string `json:"addresses,omitempty"`
+	// StreamLimitHits counts the local connections that couldn't be forwarded
+	// because no more streams could be opened on the SPDY connection.
+	StreamLimitHits int `json:"streamLimitHits,omitempty"`
+	// LastStreamError is the last error creating a stream for a local connection.
+	LastStreamError string `json:"lastStreamError,omitempty"`
+	// NodeName is the node the target pod runs on.
+	NodeName string `json:"nodeName,omitempty"`
+	// ReusePort tells whether SO_REUSEPORT is set on the local listener.
+	ReusePort bool `json:"reusePort,omitempty"`
+	// Protocol is the port forward subprotocol negotiated with the apiserver
+	// over the SPDY connection, e.g. "portforward.k8s.io".
+	Protocol string `json:"protocol,omitempty"`
+	// ForwarderOutput are the lines the port forwarder of the current tunnel
+	// wrote once ready, e.g. "Forwarding from 127.0.0.1:PORT -> TARGET", the
+	// addresses being the ones the local listeners proxy to.
+	ForwarderOutput []string `json:"forwarderOutput,omitempty"`
+	// PodTemplateHash is the ReplicaSet revision the pods of the port forward belong to.
+	PodTemplateHash string `json:"podTemplateHash,omitempty"`
+	// PodAnnotationKey and PodAnnotationValue are the annotation of the pods of the port forward.
+	PodAnnotationKey   string `json:"podAnnotationKey,omitempty"`
+	PodAnnotationValue string `json:"podAnnotationValue,omitempty"`
+	// CronJob is the CronJob whose latest Job's pods are targeted, and Job
+	// the Job of the current pod.
+	CronJob string `json:"cronJob,omitempty"`
+	Job     string `json:"job,omitempty"`
+	// LabelSelector is the label selector of the ready pods targeted.
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// PodSelectionStrategy is how the pod was picked among the ones of the
+	// pod selection, and is picked again on retargets.
+	PodSelectionStrategy string `json:"podSelectionStrategy,omitempty"`
+	// ReconnectCount is the number of times the port forward was re-established
+	// under the same id, by starting it again or retargeting it.
+	ReconnectCount int `json:"reconnectCount"`
+	// LastReconnectAt is when the port forward was last re-established.
+	LastReconnectAt *time.Time `json:"lastReconnectAt,omitempty"`
+	// ConnectionIdleTimeoutSeconds is the idle timeout of the local connections, if any.
+	ConnectionIdleTimeoutSeconds int `json:"connectionIdleTimeoutSeconds,omitempty"`
+	// MaxConnections is the most local connections proxied at once, if limited.
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// ReadinessTimeoutSeconds is the readiness timeout of the tunnels, if not the default.
+	ReadinessTimeoutSeconds int `json:"readinessTimeoutSeconds,omitempty"`
+	// IdleTimeoutSeconds is the inactivity after which the port forward is stopped, if any.
+	IdleTimeoutSeconds int `json:"idleTimeoutSeconds,omitempty"`
+	// LivenessCheck tells whether the local ports are checked to still accept connections.
+	LivenessCheck bool `json:"livenessCheck,omitempty"`
+	// KeepAliveSeconds is how often the tunnel is nudged to keep it alive, if ever.
+	KeepAliveSeconds int `json:"keepAliveSeconds,omitempty"`
+	// NotRunningGraceChecks is the number of pod checks in a row the pod may
+	// not be running for before it's lost.
+	NotRunningGraceChecks int `json:"notRunningGraceChecks,omitempty"`
+	// WatchPod tells whether the pod is watched rather than checked periodically.
+	WatchPod bool `json:"watchPod,omitempty"`
+	// TolerateContainerRestarts tells whether the pod not running while its
+	// containers restart in place is waited for rather than lost.
+	TolerateContainerRestarts bool `json:"tolerateContainerRestarts,omitempty"`
+	// WebSocket tells whether the port forward is bridged to WebSocket
+	// connections rather than listening on local ports.
+	WebSocket bool `json:"webSocket,omitempty"`
+	// IdleConnectionsReaped counts the local connections closed for being idle.
+	IdleConnectionsReaped int `json:"idleConnectionsReaped,omitempty"`
+	// SocketOptions are the socket options of the local connections.
+	SocketOptions *socketOptions `json:"socketOptions,omitempty"`
+	// ServiceResolution is how the service port was resolved to the target port
+	// and pod, when port forwarding to a service port.
+	ServiceResolution *serviceResolution `json:"serviceResolution,omitempty"`
+	// AutoReconnect tells whether the port forward reconnects to a ready pod
+	// of the workload of its pod, Owner, once its pod is gone.
+	AutoReconnect bool   `json:"autoReconnect,omitempty"`
+	Owner         string `json:"owner,omitempty"`
+	// TargetKind and TargetName are the workload targeted instead of a pod, if any.
+	TargetKind string `json:"targetKind,omitempty"`
+	TargetName string `json:"targetName,omitempty"`
+	// MonitorDisabled tells whether the pod monitor is disabled, in which case
+	// the port forward isn't stopped nor retargeted when its pod is lost.
+	MonitorDisabled bool `json:"monitorDisabled"`
+	// CreatedAt is when the port forward was first started, kept when it's started again.
+	CreatedAt time.Time `json:"createdAt"`
+	// StartedAt is when the port forward was last started.
+	StartedAt time.Time `json:"startedAt"`
+	// ReadyAt is when the port forward last became ready, once it did.
+	ReadyAt *time.Time `json:"readyAt,omitempty"`
+	// StoppedAt is when the port forward stopped, while it's stopped.
+	StoppedAt *time.Time `json:"stoppedAt,omitempty"`
+	// Warning is why the port forward may not work as expected, e.g. its pod
+	// not being ready when it was started.
+	Warning string `json:"warning,omitempty"`
+	// HostNetwork tells the pod is on the host network, the port forward
+	// reaching the ports of its node.
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+	// ProbeResult is the result of the probe requested once running, if any.
+	ProbeResult *probeResult `json:"probeResult,omitempty"`
+	// lastPodCheck is the unix time in nanoseconds of the last pod check made
+	// by the pod monitor. It is shared by all the copies of the port forward.
+	lastPodCheck *atomic.Int64
+	// lastActivity is the unix time in nanoseconds of the last local
+	// connection or data forwarded, shared as lastPodCheck is.
+	lastActivity *atomic.Int64
+	// traffic are the traffic counters of the local connections, shared as lastPodCheck is.
+	traffic *trafficStats
+	// apiLatency is the average round trip of the pod checks to the API
+	// server, shared as lastPodCheck is.
+	apiLatency *latencyAverage
+	// history are the last events of the port forward, shared as lastPodCheck is.
+	history *eventHistory
+	// listeners are the local listeners of the running port forward, shared
+	// as lastPodCheck is, so it can be drained when stopped.
+	listeners localListeners
+	// podLost receives the pod losses reported by the pod monitor.
+	podLost chan podLoss
+	// rebinds receives the requests moving a local port, handled by the
+	// supervisor of the tunnel as it owns the listeners.
+	rebinds chan rebindRequest
+	// exited is closed once the supervisor of the tunnel returned, its
+	// connection to the API server being closed, or once the port forward
+	// failed to become ready.
+	exited chan struct{}
+	// serviceSelector is the selector of the pods of the service, when port
+	// forwarding to a service port.
+	serviceSelector labels.Set
+	// ownerSelector is the selector of the pods of the workload of the pod,
+	// when auto reconnecting.
+	ownerSelector labels.Set
+	// monitorDisabled is MonitorDisabled, shared by all the copies of the
+	// port forward so the pod monitor sees it change.
+	monitorDisabled *atomic.Bool
+	// name is Name, shared by all the copies of the port forward so renaming
+	// it isn't undone by the copies stored later.
+	name *atomic.Pointer[string]
+	// probeResult is ProbeResult, shared by all the copies of the port forward
+	// as the probe runs alongside the supervisor of its tunnel.
+	probeResult *atomic.Pointer[probeResult]
+	// resumed tells the port forward is started again, its readiness being a
+	// reconnection.
+	resumed bool
+	// setupSpan is the span of the request which started the port forward,
+	// which the exemplars of its metrics link to.
+	setupSpan trace.SpanContext
+	// request is the request the port forward was started with, to resume it.
+	request *portForwardRequest
+	// credentials identifies the token and impersonation the port forward was
+	// started with, for only the same user to be returned it as a duplicate.
+	credentials string
+	// Ports are the port pairs forwarded, when started with several. Port,
+	// TargetPort and TargetPortName are always the ones of the first.
+	Ports []PortPair `json:"ports,omitempty"`
 }
 
-func (r *stopOrDeletePortForwardRequest) Validate() error {
-	if r.ID == "" {
-		return errors.New("invalid request, id is required")
+// portPairs returns the port pairs forwarded.
+func (p *portForward) portPairs() []PortPair {
+	if len(p.Ports) == 0 {
+		return []PortPair{{Port: p.Port, TargetPort: p.TargetPort, TargetPortName: p.TargetPortName}}
 	}
 
-	if r.Cluster == "" {
-		return errors.New("invalid request, cluster is required")
+	return append([]PortPair{}, p.Ports...)
+}
+
+// setPortPairs sets the port pairs forwarded.
+func (p *portForward) setPortPairs(pairs []PortPair) {
+	p.Port = pairs[0].Port
+	p.TargetPort = pairs[0].TargetPort
+	p.TargetPortName = pairs[0].TargetPortName
+
+	if len(p.Ports) > 0 || len(pairs) > 1 {
+		p.Ports = pairs
 	}
+}
 
-	return nil
+// markReconnected records that the port forward was re-established.
+func (p *portForward) markReconnected() {
+	now := time.Now()
+
+	p.ReconnectCount++
+	p.LastReconnectAt = &now
+}
+
+// isIdle tells whether the port forward had no activity for its idle timeout, if any.
+func (p *portForward) isIdle() bool {
+	if p.IdleTimeoutSeconds <= 0 || p.lastActivity == nil {
+		return false
+	}
+
+	return time.Since(time.Unix(0, p.lastActivity.Load())) >= time.Duration(p.IdleTimeoutSeconds)*time.Second
+}
+
+// readinessTimeout returns how long to wait for a tunnel of the port forward to become ready.
+func (p *portForward) readinessTimeout() time.Duration {
+	if p.ReadinessTimeoutSeconds > 0 {
+		return time.Duration(p.ReadinessTimeoutSeconds) * time.Second
+	}
+
+	return PortForwardReadinessTimeout
+}
+
+// podSelection returns the selection of the pods the port forward can target.
+func (p *portForward) podSelection() podSelection {
+	selector := p.serviceSelector
+	if len(selector) == 0 {
+		selector = p.ownerSelector
+	}
+
+	return podSelection{
+		labels:          selector,
+		podTemplateHash: p.PodTemplateHash,
+		annotationKey:   p.PodAnnotationKey,
+		annotationValue: p.PodAnnotationValue,
+		cronJob:         p.CronJob,
+		selector:        p.LabelSelector,
+		strategy:        p.PodSelectionStrategy,
+	}
+}
+
+// podSelection returns the selection of the pods the request targets.
+func (p *portForwardRequest) podSelection() podSelection {
+	return podSelection{
+		podTemplateHash: p.PodTemplateHash,
+		annotationKey:   p.PodAnnotationKey,
+		annotationValue: p.PodAnnotationValue,
+		cronJob:         p.CronJob,
+		selector:        p.LabelSelector,
+		strategy:        p.PodSelectionStrategy,
+	}
+}
+
+// getFreePort returns a free local port, within PortRangeMin and PortRangeMax
+// if set.
+func getFreePort() (int, error) {
+	if PortRangeMax == 0 {
+		return getFreePortAt(0)
+	}
+
+	for port := PortRangeMin; port <= PortRangeMax; port++ {
+		free, err := getFreePortAt(port)
+		if !isAddrInUse(err) {
+			return free, err
+		}
+	}
+
+	return 0, newError(ErrCodePortUnavailable, nil, "no free local port in range %d-%d", PortRangeMin, PortRangeMax)
+}
+
+// getFreePortAt returns port if it is free, or a free port if port is 0.
+func getFreePortAt(port int) (int, error) {
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return 0, err
+	}
+
+	l, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// requestIDHeader is the header of the requests, and responses, carrying the
+// id correlating the log lines of a port forward.
+const requestIDHeader = "X-Request-ID"
+
+// requestID returns the request's X-Request-ID header, or a new id if unset.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+
+	return uuid.New().String()
+}
+
+// logParams returns params along with the id of the port forward request,
+// and the id correlating its log lines.
+func (p *portForwardRequest) logParams(params map[string]string) map[string]string {
+	return correlatedLogParams(p.ID, p.requestID, params)
+}
+
+// logParams returns params along with the id of the port forward, and the id
+// correlating its log lines, so a port forward's lifecycle can be followed
+// across the goroutines handling it.
+func (p *portForward) logParams(params map[string]string) map[string]string {
+	return correlatedLogParams(p.ID, p.RequestID, params)
+}
+
+// correlatedLogParams returns a copy of params with the id and requestId ones.
+func correlatedLogParams(id, requestID string, params map[string]string) map[string]string {
+	logParams := map[string]string{"id": id}
+	if requestID != "" {
+		logParams["requestId"] = requestID
+	}
+
+	maps.Copy(logParams, params)
+
+	return logParams
+}
+
+// bearerToken returns the bearer token of the request's Authorization header.
+func bearerToken(r *http.Request) string {
+	reqToken := r.Header.Get("Authorization")
+	splitToken := strings.Split(reqToken, "Bearer ")
+
+	if reqToken != "" && len(splitToken) >= 2 {
+		return splitToken[1]
+	}
+
+	return ""
+}
+
+// impersonation returns the user and groups to impersonate in the cluster,
+// given by the X-Impersonate-User header and the X-Impersonate-Group or
+// comma separated X-Impersonate-Groups headers of the request.
+func impersonation(r *http.Request) (rest.ImpersonationConfig, error) {
+	impersonate := rest.ImpersonationConfig{UserName: strings.TrimSpace(r.Header.Get("X-Impersonate-User"))}
+
+	for _, values := range append(r.Header.Values("X-Impersonate-Group"), r.Header.Values("X-Impersonate-Groups")...) {
+		for _, group := range strings.Split(values, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				impersonate.Groups = append(impersonate.Groups, group)
+			}
+		}
+	}
+
+	if impersonate.UserName == "" && len(impersonate.Groups) > 0 {
+		return rest.ImpersonationConfig{}, newError(ErrCodeInvalidRequest, nil,
+			"X-Impersonate-Group requires X-Impersonate-User to be set")
+	}
+
+	return impersonate, nil
+}
+
+// userClusterName returns the name the cluster is stored under, which has
+// the X-HEADLAMP-USER-ID appended for dynamically configured clusters.
+func userClusterName(r *http.Request, cluster string) string {
+	userID := r.Header.Get("X-HEADLAMP-USER-ID")
+	if userID != "" {
+		return cluster + userID
+	}
+
+	return cluster
+}
+
+// sanitizeServerURL returns the URL of the API server at host, without any
+// credentials, query or fragment, or "" if it isn't a valid URL. The host may
+// lack a scheme, HTTPS being used then.
+func sanitizeServerURL(host string) string {
+	if host == "" {
+		return ""
+	}
+
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+
+	u, err := url.Parse(host)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+// StartPortForward handles the port forward request.
+func StartPortForward(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}],
+	w http.ResponseWriter, r *http.Request,
+) {
+	var p portForwardRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding portforward payload")
+		writeError(w, newError(ErrCodeInvalidRequest, err, "failed to marshal port forward payload"))
+
+		return
+	}
+
+	p.clusterKey = userClusterName(r, p.Cluster)
+	p.reuseRunning = true
+
+	pf, err := startPortForwardRequest(kubeConfigStore, cache, &p, r)
+	if err != nil {
+		writeError(w, err)
+
+		return
+	}
+
+	var payload interface{}
+
+	if p.DryRun {
+		payload = dryRunResult{
+			Valid:             true,
+			Namespace:         pf.Namespace,
+			Pod:               pf.Pod,
+			TargetPort:        pf.TargetPort,
+			Ports:             pf.Ports,
+			ServiceResolution: pf.ServiceResolution,
+		}
+	} else {
+		p.setBound(pf)
+		payload = p
+	}
+
+	if pf.RequestID != "" {
+		w.Header().Set(requestIDHeader, pf.RequestID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response write")
+		http.Error(w, "failed to write json payload to response write "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// startPortForwardRequest validates the port forward request, filling in its
+// defaults, and starts the port forward. Failures are PortForwardErrors.
+func startPortForwardRequest(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}],
+	p *portForwardRequest, r *http.Request,
+) (_ portForward, err error) {
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+
+	if p.requestID == "" {
+		p.requestID = requestID(r)
+	}
+
+	if p.clusterKey == "" {
+		p.clusterKey = userClusterName(r, p.Cluster)
+	}
+
+	ctx, span := telemetry.CreateSpan(r.Context(), r, "portforward", "startPortForward",
+		attribute.String("portforward.id", p.ID),
+		attribute.String("portforward.cluster", p.Cluster),
+		attribute.String("portforward.namespace", p.Namespace),
+	)
+
+	defer func() {
+		if err != nil {
+			pf := &portForward{
+				ID: p.ID, Cluster: p.storedCluster(), ClusterName: p.Cluster, Namespace: p.Namespace,
+				setupSpan: span.SpanContext(),
+			}
+			recordError(pf, errorStageSetup, err)
+
+			if isRBACDenial(p, err) {
+				recordRBACDenial(pf)
+			}
+		}
+
+		telemetry.EndSpan(ctx, err)
+	}()
+
+	token := bearerToken(r)
+
+	if err := p.Validate(); err != nil {
+		logger.Log(logger.LevelError, p.logParams(nil), err, "validating portforward payload")
+
+		return portForward{}, err
+	}
+
+	impersonate, err := impersonation(r)
+	if err != nil {
+		logger.Log(logger.LevelError, p.logParams(nil), err, "validating portforward impersonation")
+
+		return portForward{}, err
+	}
+
+	if isDeniedNamespace(p.Namespace) && !p.AllowSystemNamespace {
+		err := newError(ErrCodeForbidden, nil, "port forwarding in the %s namespace is denied, "+
+			"set allowSystemNamespace to forward to it anyway", p.Namespace)
+		logger.Log(logger.LevelError, p.logParams(map[string]string{"namespace": p.Namespace}), err,
+			"validating portforward payload")
+
+		return portForward{}, err
+	}
+
+	if err := p.checkBindPolicy(); err != nil {
+		logger.Log(logger.LevelError, p.logParams(nil), err, "validating portforward payload")
+
+		return portForward{}, err
+	}
+
+	// The duplicates are looked up once the request is validated, with its
+	// ports normalized.
+	if p.reuseRunning {
+		if pf, ok := runningDuplicate(cache, *p, credentialsKey(token, impersonate)); ok {
+			logger.Log(logger.LevelInfo, map[string]string{"id": pf.ID, "cluster": pf.Cluster}, nil,
+				"portforward already running, returning it")
+
+			p.ID = pf.ID
+
+			return pf, nil
+		}
+	}
+
+	kContext, err := kubeConfigStore.GetContext(p.storedCluster())
+	if err != nil {
+		logger.Log(logger.LevelError, p.logParams(map[string]string{"cluster": p.Cluster}),
+			err, "getting kubeconfig context")
+
+		return portForward{}, newError(ErrCodeNotFound, err, "cluster %s not found", p.Cluster)
+	}
+
+	// A port forward the user isn't allowed is refused before taking a slot
+	// of the cluster, the dry runs checking the target they resolve instead.
+	if !p.DryRun {
+		if err := checkStartPermission(ctx, kContext, *p, token, impersonate); err != nil {
+			logger.Log(logger.LevelError, p.logParams(map[string]string{"namespace": p.Namespace}), err,
+				"checking portforward permission")
+
+			return portForward{}, err
+		}
+	}
+
+	release, err := reservePortForward(cache, p.storedCluster())
+	if err != nil {
+		logger.Log(logger.LevelError, p.logParams(map[string]string{"cluster": p.Cluster}), err,
+			"checking portforward limit")
+
+		return portForward{}, err
+	}
+
+	defer release()
+
+	pf, err := startPortForward(ctx, kContext, cache, *p, token, impersonate)
+	if err != nil {
+		err = unreachableError(err)
+		logger.Log(logger.LevelError, p.logParams(nil), err, "starting portforward")
+
+		return portForward{}, err
+	}
+
+	if !p.DryRun {
+		recordStart(&pf)
+	}
+
+	return pf, nil
+}
+
+// checkStartPermission checks the user is allowed to port forward to the
+// target of the request, and to resolve its service if any, within
+// PermissionCheckTimeout.
+func checkStartPermission(ctx context.Context, kContext *kubeconfig.Context, p portForwardRequest, token string,
+	impersonate rest.ImpersonationConfig,
+) error {
+	clientset, _, err := getKubeClientAndConfig(kContext, token, impersonate)
+	if err != nil {
+		return newError(ErrCodeInternal, err, "failed to setup Kubernetes client/config")
+	}
+
+	return checkPortForwardPermission(ctx, clientset, p.accessTarget())
+}
+
+// accessTarget returns the target the permission of the request is checked
+// for before its pod is resolved: any pod of the namespace of the service, if
+// the pod is resolved from a service, as starting the port forward resolves it.
+func (p *portForwardRequest) accessTarget() accessTarget {
+	if p.ServicePort != "" || (p.Service != "" && p.Pod == "" && p.podSelection().isEmpty()) {
+		namespace := p.Namespace
+		if p.ServiceNamespace != "" {
+			namespace = p.ServiceNamespace
+		}
+
+		return accessTarget{namespace: namespace, service: p.Service}
+	}
+
+	return accessTarget{namespace: p.Namespace, pod: p.Pod}
+}
+
+// isRBACDenial tells whether the port forward was denied for lack of permissions
+// in its cluster, rather than by the denied namespaces or the bind policy.
+func isRBACDenial(p *portForwardRequest, err error) bool {
+	if errorCode(err) != ErrCodeForbidden || apierrors.IsUnauthorized(err) {
+		return false
+	}
+
+	if isDeniedNamespace(p.Namespace) && !p.AllowSystemNamespace {
+		return false
+	}
+
+	return p.checkBindPolicy() == nil
+}
+
+// dryRunResult is the response of a dry run, with the target the port forward
+// was resolved to.
+type dryRunResult struct {
+	Valid             bool               `json:"valid"`
+	Namespace         string             `json:"namespace"`
+	Pod               string             `json:"pod"`
+	TargetPort        string             `json:"targetPort"`
+	Ports             []PortPair         `json:"ports,omitempty"`
+	ServiceResolution *serviceResolution `json:"serviceResolution,omitempty"`
+}
+
+// checkDryRun checks the resolved pod of the port forward is running and the
+// user is allowed to port forward to it, and to resolve its service if any.
+func checkDryRun(ctx context.Context, clientset kubernetes.Interface, pfDetails *portForward) error {
+	if err := checkIfPodIsRunning(ctx, clientset, pfDetails.Namespace, pfDetails.Pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return newError(ErrCodeNotFound, err, "getting pod %s/%s", pfDetails.Namespace, pfDetails.Pod)
+		}
+
+		return newError(ErrCodeStopped, err, "pod %s/%s", pfDetails.Namespace, pfDetails.Pod)
+	}
+
+	target := accessTarget{namespace: pfDetails.Namespace, pod: pfDetails.Pod}
+	if pfDetails.ServiceResolution != nil {
+		target.service = pfDetails.ServiceResolution.Service
+	}
+
+	return checkPortForwardPermission(ctx, clientset, target)
+}
+
+// runningDuplicate returns the running port forward of the cluster to the same
+// pod and target ports as the request, and the same local ports if set, if
+// any, unless the request forces a new one or is a dry run. It must have been
+// started with the same credentials, local addresses and dial headers.
+func runningDuplicate(cache cache.Cache[interface{}], p portForwardRequest, credentials string) (portForward, bool) {
+	if p.ForceNew || p.DryRun || p.Cluster == "" || p.Pod == "" {
+		return portForward{}, false
+	}
+
+	portForwards, err := getPortForwardList(cache, p.storedCluster())
+	if err != nil {
+		return portForward{}, false
+	}
+
+	for _, pf := range portForwards {
+		if pf.Cluster == p.storedCluster() && pf.Status == RUNNING && pf.Namespace == p.Namespace && pf.Pod == p.Pod &&
+			pf.Container == p.Container && pf.WebSocket == p.WebSocket && samePortPairs(p.portPairs(), pf.portPairs()) &&
+			pf.credentials == credentials && pf.request != nil && sameBinding(p, *pf.request) {
+			return pf, true
+		}
+	}
+
+	return portForward{}, false
+}
+
+// sameBinding tells whether the requests listen on the same local addresses,
+// and dial the API server with the same headers.
+func sameBinding(requested portForwardRequest, started portForwardRequest) bool {
+	return requested.BindAddress == started.BindAddress && slices.Equal(requested.Addresses, started.Addresses) &&
+		requested.Interface == started.Interface && maps.Equal(requested.DialHeaders, started.DialHeaders)
+}
+
+// credentialsKey returns a digest of the token and impersonation a port forward
+// is started with, which tells the users apart without keeping the token.
+func credentialsKey(token string, impersonate rest.ImpersonationConfig) string {
+	digest := sha256.New()
+	_, _ = fmt.Fprintf(digest, "%q %q %q", token, impersonate.UserName, impersonate.Groups)
+
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// samePortPairs tells whether the port pairs of a request are the ones
+// forwarded, by target port number or name, and by local port if set.
+func samePortPairs(requested []PortPair, forwarded []PortPair) bool {
+	if len(requested) != len(forwarded) {
+		return false
+	}
+
+	for i, pair := range requested {
+		if pair.TargetPort != forwarded[i].TargetPort && pair.TargetPort != forwarded[i].TargetPortName {
+			return false
+		}
+
+		if pair.Port != "" && pair.Port != forwarded[i].Port {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startingPortForwards counts the port forwards of each cluster being
+// started, which are not stored as running yet but count towards the limit.
+var (
+	startingPortForwards     = map[string]int{}
+	startingPortForwardsLock sync.Mutex
+)
+
+// reservePortForward reserves a port forward of the cluster for the start,
+// without going over MaxPortForwardsPerCluster running and starting ones,
+// the count and the reservation being atomic so concurrent starts can't both
+// take the last one. It returns the function releasing it once the start
+// returned, the port forward being stored as running by then if it started.
+func reservePortForward(cache cache.Cache[interface{}], cluster string) (func(), error) {
+	if MaxPortForwardsPerCluster <= 0 {
+		return func() {}, nil
+	}
+
+	startingPortForwardsLock.Lock()
+	defer startingPortForwardsLock.Unlock()
+
+	portForwards, err := getPortForwardList(cache, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	running := startingPortForwards[cluster]
+
+	for _, pf := range portForwards {
+		if pf.Cluster == cluster && pf.Status == RUNNING {
+			running++
+		}
+	}
+
+	if running >= MaxPortForwardsPerCluster {
+		return nil, newError(ErrCodeLimitReached, nil, "maximum concurrent port forwards reached")
+	}
+
+	startingPortForwards[cluster]++
+
+	return func() {
+		startingPortForwardsLock.Lock()
+		defer startingPortForwardsLock.Unlock()
+
+		startingPortForwards[cluster]--
+		if startingPortForwards[cluster] == 0 {
+			delete(startingPortForwards, cluster)
+		}
+	}, nil
+}
+
+// clientSetupAttempts bounds the attempts at creating the Kubernetes client
+// and config of a port forward, which is retried when it fails transiently,
+// e.g. because of a credential plugin momentarily failing.
+const clientSetupAttempts = 3
+
+// clientSetupBackoff is the delay before the first retry, doubled for the next ones.
+var clientSetupBackoff = 200 * time.Millisecond
+
+// isPermanentClientSetupError tells whether creating the Kubernetes client
+// failed because of a misconfiguration, which retrying can't fix.
+func isPermanentClientSetupError(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+
+	// The clientcmd checks don't unwrap the errors.
+	for ; err != nil; err = errors.Unwrap(err) {
+		if clientcmd.IsConfigurationInvalid(err) || clientcmd.IsEmptyConfig(err) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getKubeClientAndConfig prepares Kubernetes clientset and REST config.
+// It takes a kubeconfig context, an optional bearer token and the optional
+// user to impersonate, which both the clientset and the port forwards act as.
+// It returns the configured clientset, REST config, or an error if setup fails.
+// Setting them up is retried with backoff when it fails transiently.
+func getKubeClientAndConfig(kContext *kubeconfig.Context, token string,
+	impersonate rest.ImpersonationConfig,
+) (*kubernetes.Clientset, *rest.Config, error) {
+	backoff := clientSetupBackoff
+
+	for attempt := 1; ; attempt++ {
+		clientset, rConf, err := newKubeClientAndConfig(kContext, token, impersonate)
+		if err == nil || attempt == clientSetupAttempts || isPermanentClientSetupError(err) {
+			return clientset, rConf, err
+		}
+
+		logger.Log(logger.LevelWarn, map[string]string{"context": kContext.Name, "attempt": strconv.Itoa(attempt)},
+			err, "creating Kubernetes client, retrying")
+		time.Sleep(backoff)
+
+		backoff *= 2
+	}
+}
+
+// newKubeClientAndConfig creates the clientset and REST config of the context.
+// The credentials of the context, e.g. its client certificate, are kept, the
+// bearer token being added rather than replacing them, and only if set.
+func newKubeClientAndConfig(kContext *kubeconfig.Context, token string,
+	impersonate rest.ImpersonationConfig,
+) (*kubernetes.Clientset, *rest.Config, error) {
+	rConf, err := kContext.RESTConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get REST config: %w", err)
+	}
+
+	if token != "" {
+		rConf.BearerToken = token
+	}
+
+	rConf.Impersonate = impersonate
+
+	clientset, err := kubernetes.NewForConfig(rConf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+
+	return clientset, rConf, nil
+}
+
+// syncBuffer is a bytes.Buffer guarded by a mutex, since the port forwarder
+// keeps writing to its output while the buffer is being read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// portForwardURL returns the URL of the portforward subresource of the pod on
+// the apiserver at host. The host may lack a scheme, "1.2.3.4:6443", in which
+// case https is assumed, and may have a path, which the subresource path is
+// appended to, as with apiservers behind a proxy. Bare IPv6 literals are bracketed.
+func portForwardURL(host, namespace, podName string) (*url.URL, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return nil, errors.New("invalid REST config host: host is empty")
+	}
+
+	// A bare IPv6 literal can't be told apart from a host and port
+	// once in a URL, so it needs brackets.
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REST config host %q: %w", host, err)
+	}
+
+	if hostURL.Scheme != "https" && hostURL.Scheme != "http" {
+		return nil, fmt.Errorf("invalid REST config host %q: unsupported scheme %q", host, hostURL.Scheme)
+	}
+
+	if hostURL.Hostname() == "" {
+		return nil, fmt.Errorf("invalid REST config host %q: missing host name", host)
+	}
+
+	return hostURL.JoinPath("api", "v1", "namespaces", namespace, "pods", podName, "portforward"), nil
+}
+
+// initPortForwarder sets up the SPDY dialer and creates a new port forwarder.
+// It requires a REST config, namespace, pod name, the port mapping strings (e.g., "0:80"),
+// the headers to add to the upgrade request, a callback for the negotiated protocol
+// or dial error and one for stream creation errors. The port forwarder only listens on
+// forwarderAddress, local connections are accepted by a localListener.
+// It returns the port forwarder instance, stop/ready channels, output/error buffers, or an error.
+func initPortForwarder(rConf *rest.Config, namespace, podName string, portMappings []string,
+	dialHeaders map[string]string, onDial func(protocol string, err error), onStreamError func(err error),
+) (
+	*portforward.PortForwarder, chan struct{}, chan struct{}, *syncBuffer, *syncBuffer, error,
+) {
+	roundTripper, upgrader, err := spdy.RoundTripperFor(rConf)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("failed to create SPDY round tripper: %w", err)
+	}
+
+	fullURL, err := portForwardURL(rConf.Host, namespace, podName)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+
+	var transport http.RoundTripper = roundTripper
+
+	if len(dialHeaders) > 0 {
+		logger.Log(logger.LevelInfo, map[string]string{"namespace": namespace, "pod": podName,
+			"headers": redactHeaders(dialHeaders)}, nil, "adding dial headers to portforward upgrade request")
+
+		transport = &headerRoundTripper{RoundTripper: roundTripper, headers: dialHeaders}
+	}
+
+	// The client timeout bounds dialing the API server, the dialer the
+	// upgrade, whose response is read from the connection opened.
+	client := &http.Client{Transport: transport, Timeout: DialTimeout}
+
+	dialer := &streamTrackingDialer{
+		Dialer:        spdy.NewDialer(upgrader, client, http.MethodPost, fullURL),
+		timeout:       DialTimeout,
+		onDial:        onDial,
+		onStreamError: onStreamError,
+	}
+	stopChan, readyChan := make(chan struct{}), make(chan struct{}, 1)
+	out, errOut := new(syncBuffer), new(syncBuffer)
+
+	forwarder, err := portforward.NewOnAddresses(
+		dialer, []string{forwarderAddress}, portMappings, stopChan, readyChan, out, errOut,
+	)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("failed to create portforwarder: %w", err)
+	}
+
+	return forwarder, stopChan, readyChan, out, errOut, nil
+}
+
+// contextUntil returns a context derived from parent which is also canceled
+// once stop is closed.
+func contextUntil(parent context.Context, stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// safeCloseChan attempts to close a channel and recovers from a panic
+// if the channel is already closed or nil.
+func safeCloseChan(ch chan struct{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			_ = r
+		}
+	}()
+
+	if ch != nil {
+		close(ch)
+	}
+}
+
+// recordStreamError keeps track of a failure to create a stream for a local
+// connection to the pod of the port forward, counting the ones caused by the
+// stream limit. The counters are the shared ones, the connection handlers
+// reporting the failures alongside the supervisor of the tunnel.
+func recordStreamError(pfDetails *portForward, pod string, err error) {
+	logParams := pfDetails.logParams(map[string]string{"pod": pod, "namespace": pfDetails.Namespace})
+
+	if errors.Is(err, ErrStreamLimitReached) {
+		pfDetails.traffic.streamLimitHits.Add(1)
+
+		logger.Log(logger.LevelError, logParams, err, "SPDY stream limit reached, connection not forwarded")
+	} else {
+		logger.Log(logger.LevelError, logParams, err, "creating stream for connection")
+	}
+
+	lastStreamError := err.Error()
+	pfDetails.traffic.lastStreamError.Store(&lastStreamError)
+
+	recordError(pfDetails, errorStageStream, err)
+}
+
+// recordIdleReap counts a local connection of the port forward closed for
+// being idle, in the counters shared with the supervisor of the tunnel.
+func recordIdleReap(pfDetails *portForward) {
+	pfDetails.traffic.idleConnectionsReaped.Add(1)
+}
+
+// apiLatencySamples is the number of pod checks the API server latency of a
+// port forward is averaged over, a new check weighing 1/apiLatencySamples.
+const apiLatencySamples = 5
+
+// latencyAverage is the rolling average of the round trips of the pod checks
+// of a port forward to the API server, updated by the pod monitor and read
+// concurrently.
+type latencyAverage struct {
+	mu      sync.Mutex
+	average time.Duration
+	samples int
+}
+
+// add accounts for the round trip of a pod check.
+func (a *latencyAverage) add(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.samples < apiLatencySamples {
+		a.samples++
+	}
+
+	a.average += (d - a.average) / time.Duration(a.samples)
+}
+
+// milliseconds returns the average in milliseconds, 0 if no check was made.
+func (a *latencyAverage) milliseconds() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return float64(a.average.Microseconds()) / 1000
+}
+
+// monitorPodAndManagePortForward runs in a goroutine and periodically checks if the
+// target pod of a tunnel is still running, the interval being jittered. If the pod is not running
+// (or if an unrecoverable error occurs during check), it reports the pod loss
+// to the tunnel supervisor, which retargets or stops the port-forward. A pod
+// not running is tolerated for the NotRunningGraceChecks of the port forward,
+// and for as long as its containers restart in place with TolerateContainerRestarts.
+// It stops when the tunnel's stopChan is closed, interrupting a check in
+// progress, and skips the checks while the monitor of the port forward is disabled.
+// With WatchPod, the pod is watched instead, and checked periodically only
+// if the watch fails.
+func monitorPodAndManagePortForward(
+	clientset kubernetes.Interface,
+	pfDetails *portForward,
+	t *tunnel,
+) {
+	timer := time.NewTimer(podCheckInterval())
+	defer timer.Stop()
+
+	ctx, cancel := contextUntil(context.Background(), t.stopChan)
+	defer cancel()
+
+	logParams := pfDetails.logParams(map[string]string{"pod": t.pod, "namespace": pfDetails.Namespace})
+
+	if pfDetails.WatchPod && watchPodAndManagePortForward(ctx, clientset, pfDetails, t, logParams) {
+		return
+	}
+
+	// notRunning counts the checks in a row the pod wasn't running in.
+	notRunning := 0
+
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(podCheckInterval())
+
+			if pfDetails.monitorDisabled != nil && pfDetails.monitorDisabled.Load() {
+				continue
+			}
+
+			if pfDetails.lastPodCheck != nil {
+				pfDetails.lastPodCheck.Store(time.Now().UnixNano())
+			}
+
+			checkStart := time.Now()
+
+			err := checkIfPodIsRunning(ctx, clientset, pfDetails.Namespace, t.pod)
+
+			// The checks which didn't get an answer don't tell the round trip.
+			if pfDetails.apiLatency != nil && !isConnectionError(err) && !errors.Is(err, context.DeadlineExceeded) {
+				pfDetails.apiLatency.add(time.Since(checkStart))
+			}
+
+			if err != nil {
+				if ctx.Err() != nil {
+					logger.Log(logger.LevelInfo, logParams, nil, "Pod monitor stopping: tunnel was stopped.")
+
+					return
+				}
+
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					logger.Log(logger.LevelInfo, logParams, err, "checking pod (ECONNREFUSED), continuing")
+					pfDetails.recordEvent(eventPodCheckRetry, err.Error())
+
+					continue
+				}
+
+				if errors.Is(err, errContainersRestarting) && pfDetails.TolerateContainerRestarts {
+					logger.Log(logger.LevelWarn, logParams, err, "checking pod, waiting for its containers to restart")
+					pfDetails.recordEvent(eventPodCheckRetry, err.Error())
+
+					continue
+				}
+
+				if errors.Is(err, errPodNotRunning) && notRunning < pfDetails.NotRunningGraceChecks {
+					notRunning++
+
+					logger.Log(logger.LevelWarn, logParams, err, "checking pod, tolerating it not running")
+					pfDetails.recordEvent(eventPodCheckRetry,
+						fmt.Sprintf("%v, check %d of %d tolerated", err, notRunning, pfDetails.NotRunningGraceChecks))
+
+					continue
+				}
+
+				reportPodLost(pfDetails, t, logParams,
+					fmt.Sprintf("Pod %s/%s check failed: %v", pfDetails.Namespace, t.pod, err))
+
+				return
+			}
+
+			notRunning = 0
+		case <-ctx.Done():
+			logger.Log(logger.LevelInfo, logParams, nil, "Pod monitor stopping: tunnel was stopped.")
+
+			return
+		}
+	}
+}
+
+// reportPodLost reports the pod of the tunnel lost, for reason, to the tunnel
+// supervisor, unless a loss is already pending.
+func reportPodLost(pfDetails *portForward, t *tunnel, logParams map[string]string, reason string) {
+	logger.Log(logger.LevelError, logParams, errors.New(reason), "pod of port-forward lost")
+	pfDetails.recordEvent(eventPodLost, reason)
+
+	select {
+	case pfDetails.podLost <- podLoss{pod: t.pod, reason: reason}:
+	default:
+	}
+}
+
+// watchPodAndManagePortForward watches the pod of the tunnel, reporting it
+// lost as soon as it is deleted or stops running, and watching it again when
+// the API server ends the watch. It returns true once the pod is lost or the
+// tunnel stopped, and false if the pod can't be watched, for it to be checked
+// periodically instead. The events are ignored while the monitor is disabled.
+func watchPodAndManagePortForward(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	pfDetails *portForward,
+	t *tunnel,
+	logParams map[string]string,
+) bool {
+	opts := v1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", t.pod).String()}
+
+	for {
+		watcher, err := clientset.CoreV1().Pods(pfDetails.Namespace).Watch(ctx, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Log(logger.LevelInfo, logParams, nil, "Pod monitor stopping: tunnel was stopped.")
+
+				return true
+			}
+
+			logger.Log(logger.LevelWarn, logParams, err, "watching pod, checking it periodically instead")
+
+			return false
+		}
+
+		reason, err := watchPodLoss(ctx, watcher, pfDetails, t.pod)
+
+		watcher.Stop()
+
+		switch {
+		case ctx.Err() != nil:
+			logger.Log(logger.LevelInfo, logParams, nil, "Pod monitor stopping: tunnel was stopped.")
+
+			return true
+		case err != nil:
+			logger.Log(logger.LevelWarn, logParams, err, "watching pod, checking it periodically instead")
+
+			return false
+		case reason != "":
+			reportPodLost(pfDetails, t, logParams, reason)
+
+			return true
+		}
+	}
+}
+
+// watchPodLoss reads the events of the pod watch. It returns why once the pod
+// is deleted or stops running, the error of the watch if it fails, and
+// neither once the watch ends.
+func watchPodLoss(ctx context.Context, watcher watch.Interface, pfDetails *portForward, pod string) (string, error) {
+	// A pod which doesn't change has no events, its watch being open as good
+	// as a check of it, so the pod check is recorded periodically for the
+	// reconciliation not to consider the pod monitor dead.
+	ticker := time.NewTicker(podCheckBaseInterval)
+	defer ticker.Stop()
+
+	for {
+		var event watch.Event
+
+		select {
+		case <-ctx.Done():
+			return "", nil
+		case <-ticker.C:
+			if pfDetails.lastPodCheck != nil && (pfDetails.monitorDisabled == nil || !pfDetails.monitorDisabled.Load()) {
+				pfDetails.lastPodCheck.Store(time.Now().UnixNano())
+			}
+
+			continue
+		case e, ok := <-watcher.ResultChan():
+			if !ok {
+				return "", nil
+			}
+
+			event = e
+		}
+
+		if event.Type == watch.Error {
+			return "", apierrors.FromObject(event.Object)
+		}
+
+		p, ok := event.Object.(*corev1.Pod)
+		if !ok || p.Name != pod {
+			continue
+		}
+
+		if pfDetails.monitorDisabled != nil && pfDetails.monitorDisabled.Load() {
+			continue
+		}
+
+		if pfDetails.lastPodCheck != nil {
+			pfDetails.lastPodCheck.Store(time.Now().UnixNano())
+		}
+
+		switch {
+		case event.Type == watch.Deleted:
+			return fmt.Sprintf("Pod %s/%s was deleted", p.Namespace, pod), nil
+		case p.Status.Phase != corev1.PodRunning && pfDetails.TolerateContainerRestarts && restartingInPlace(p):
+			continue
+		case p.Status.Phase != corev1.PodRunning:
+			return fmt.Sprintf("Pod %s/%s is not running: %s", p.Namespace, pod, p.Status.Phase), nil
+		}
+	}
+}
+
+// handlePortForwardReadiness starts a tunnel and waits for it to be ready,
+// retrying transient failures, and handling errors from errOut, timeouts, or
+// premature stop signals. The warnings of errOut don't fail it and are kept
+// as its Warning. Once ready, it calls listen to start accepting the local
+// connections, and returns the tunnel and the listeners.
+// It updates the portForward details in the cache based on the outcome.
+func handlePortForwardReadiness(
+	cache cache.Cache[interface{}],
+	pfDetails *portForward,
+	start func() (*tunnel, error),
+	listen func(t *tunnel) (localListeners, error),
+	logParams map[string]string,
+) (*tunnel, localListeners, error) {
+	t, err := startReadyTunnel(start, pfDetails.closeChan, pfDetails.readinessTimeout(), logParams)
+	if errors.Is(err, errStoppedBeforeReady) {
+		logger.Log(logger.LevelInfo, logParams, nil, err.Error())
+
+		if pfDetails.Status == RUNNING {
+			pfDetails.Status = STOPPED
+		}
+
+		if pfDetails.Error == "" {
+			pfDetails.Error = err.Error()
+		}
+
+		pfDetails.recordEvent(eventStopped, err.Error())
+		portforwardstore(cache, *pfDetails)
+
+		return nil, nil, err
+	}
+
+	var listeners localListeners
+
+	if err == nil {
+		if listeners, err = listen(t); err != nil {
+			safeCloseChan(t.stopChan)
+		}
+	}
+
+	if err != nil {
+		logger.Log(logger.LevelError, logParams, err, "checking ready status")
+
+		if errors.Is(err, errReadinessTimeout) {
+			recordReadinessTimeout(pfDetails)
+		}
+
+		pfDetails.Status = STOPPED
+		pfDetails.Error = err.Error()
+
+		pfDetails.recordEvent(eventFailed, err.Error())
+		portforwardstore(cache, *pfDetails)
+		safeCloseChan(pfDetails.closeChan)
+
+		return nil, nil, err
+	}
+
+	// The local ports picked by the listeners are the ones reported.
+	pairs := pfDetails.portPairs()
+	for i, listener := range listeners {
+		pairs[i].Port = listener.Port()
+	}
+
+	pfDetails.setPortPairs(pairs)
+
+	readyAt := time.Now()
+
+	pfDetails.Status = RUNNING
+	pfDetails.Error = ""
+	pfDetails.Addresses = listeners[0].Addresses()
+	pfDetails.ReadyAt = &readyAt
+	pfDetails.Protocol = t.protocol
+	pfDetails.ForwarderOutput = t.output()
+	pfDetails.listeners = listeners
+
+	if t.warning != "" {
+		logger.Log(logger.LevelWarn, logParams, errors.New(t.warning), "portforward started with warnings")
+
+		pfDetails.Warning = joinWarnings(pfDetails.Warning, t.warning)
+	}
+
+	if pfDetails.resumed {
+		pfDetails.markReconnected()
+	}
+
+	pfDetails.recordEvent(eventRunning, "forwarding to pod "+t.pod)
+
+	// A port forward which can't be tracked couldn't be stopped, so it's not started.
+	if err := storePortForward(cache, *pfDetails); err != nil {
+		logger.Log(logger.LevelError, logParams, err, "storing running portforward")
+		listeners.Close()
+		safeCloseChan(t.stopChan)
+		safeCloseChan(pfDetails.closeChan)
+
+		return nil, nil, err
+	}
+
+	logger.Log(logger.LevelInfo, logParams, nil, "Port forward ready and running.")
+
+	return t, listeners, nil
+}
+
+// runAndMonitorPortForward starts a tunnel with start, then handles its
+// readiness, and if ready, starts goroutines supervising the tunnel and
+// monitoring the target pod's status. It returns the running tunnel, and the
+// port forward as it became ready, before the goroutines update it.
+func runAndMonitorPortForward(
+	clientset kubernetes.Interface,
+	cache cache.Cache[interface{}],
+	pfDetails *portForward,
+	start func() (*tunnel, error),
+	opts listenOptions,
+	retarget func() (*tunnel, error),
+) (*tunnel, portForward, error) {
+	logParams := pfDetails.logParams(map[string]string{
+		"pod": pfDetails.Pod, "port": pfDetails.Port, "targetPort": pfDetails.TargetPort,
+	})
+
+	listen := func(t *tunnel) (localListeners, error) {
+		targets, err := t.addresses()
+		if err != nil {
+			return nil, err
+		}
+
+		if pfDetails.WebSocket {
+			return bridgePorts(targets, opts), nil
+		}
+
+		return listenLocalPorts(pfDetails.Addresses, pfDetails.portPairs(), targets, opts)
+	}
+
+	t, listeners, err := handlePortForwardReadiness(cache, pfDetails, start, listen, logParams)
+	if err != nil {
+		safeCloseChan(pfDetails.exited)
+
+		return nil, portForward{}, err
+	}
+
+	ready := *pfDetails
+
+	go superviseTunnel(clientset, cache, pfDetails, t, listeners, retarget)
+	go monitorPodAndManagePortForward(clientset, pfDetails, t)
+
+	return t, ready, nil
+}
+
+// startPortForward starts a port forward. This is the internal function that was refactored.
+// It sets up Kubernetes clients, resolves the target pod, opens a tunnel to it and manages its lifecycle.
+// It returns the port forward details once it is ready.
+func startPortForward(ctx context.Context, kContext *kubeconfig.Context, cache cache.Cache[interface{}],
+	p portForwardRequest, token string, impersonate rest.ImpersonationConfig,
+) (portForward, error) {
+	// Explicit local ports are checked upfront, rather than failing once the tunnel is open.
+	if err := checkPortAvailable(p); err != nil {
+		return portForward{}, err
+	}
+
+	clientset, rConf, err := getKubeClientAndConfig(kContext, token, impersonate)
+	if err != nil {
+		return portForward{}, newError(ErrCodeInternal, err, "failed to setup Kubernetes client/config")
+	}
+
+	socketOptions := p.socketOptions()
+	request := p
+	request.Ports = append([]PortPair(nil), p.Ports...)
+	now := time.Now()
+
+	pfDetails := &portForward{
+		ID:                           p.ID,
+		RequestID:                    p.requestID,
+		Pod:                          p.Pod,
+		Cluster:                      p.storedCluster(),
+		ClusterName:                  p.Cluster,
+		ServerURL:                    sanitizeServerURL(rConf.Host),
+		Namespace:                    p.Namespace,
+		Service:                      p.Service,
+		ServiceNamespace:             p.ServiceNamespace,
+		TargetPort:                   p.TargetPort,
+		Container:                    p.Container,
+		Status:                       RUNNING,
+		Port:                         p.Port,
+		Error:                        "",
+		EntryTTLSeconds:              p.EntryTTLSeconds,
+		Addresses:                    p.localAddresses(),
+		ReusePort:                    p.ReusePort,
+		PodTemplateHash:              p.PodTemplateHash,
+		PodAnnotationKey:             p.PodAnnotationKey,
+		PodAnnotationValue:           p.PodAnnotationValue,
+		CronJob:                      p.CronJob,
+		LabelSelector:                p.LabelSelector,
+		CreatedAt:                    now,
+		StartedAt:                    now,
+		ConnectionIdleTimeoutSeconds: p.ConnectionIdleTimeoutSeconds,
+		MaxConnections:               p.MaxConnections,
+		ReadinessTimeoutSeconds:      p.ReadinessTimeoutSeconds,
+		IdleTimeoutSeconds:           p.IdleTimeoutSeconds,
+		LivenessCheck:                p.LivenessCheck,
+		KeepAliveSeconds:             p.KeepAliveSeconds,
+		NotRunningGraceChecks:        p.NotRunningGraceChecks,
+		WatchPod:                     p.WatchPod,
+		TolerateContainerRestarts:    p.TolerateContainerRestarts,
+		WebSocket:                    p.WebSocket,
+		MonitorDisabled:              p.DisableMonitor,
+		Name:                         p.Name,
+		SocketOptions:                &socketOptions,
+		closeChan:                    make(chan struct{}),
+		lastPodCheck:                 new(atomic.Int64),
+		lastActivity:                 new(atomic.Int64),
+		traffic:                      new(trafficStats),
+		apiLatency:                   new(latencyAverage),
+		history:                      new(eventHistory),
+		monitorDisabled:              new(atomic.Bool),
+		name:                         new(atomic.Pointer[string]),
+		probeResult:                  new(atomic.Pointer[probeResult]),
+		podLost:                      make(chan podLoss, 1),
+		rebinds:                      make(chan rebindRequest),
+		exited:                       make(chan struct{}),
+		setupSpan:                    trace.SpanContextFromContext(ctx),
+		request:                      &request,
+		credentials:                  credentialsKey(token, impersonate),
+	}
+
+	// The pod may be waited for to become ready past apiRequestTimeout.
+	readyCtx := ctx
+
+	// The target of the port forward is resolved within apiRequestTimeout.
+	ctx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	defer cancel()
+
+	pfDetails.lastPodCheck.Store(time.Now().UnixNano())
+	pfDetails.lastActivity.Store(time.Now().UnixNano())
+	pfDetails.monitorDisabled.Store(p.DisableMonitor)
+	pfDetails.name.Store(&p.Name)
+
+	pairs := p.portPairs()
+	p.TargetPort = pairs[0].TargetPort
+
+	strategy := p.PodSelectionStrategy
+
+	switch {
+	case strategy != "":
+	case p.LabelSelector != "":
+		strategy = PodSelectionRoundRobin
+	default:
+		strategy = PodSelectionReadyFirst
+	}
+
+	// The pod is got once, for the checks of the port forward.
+	var pod *corev1.Pod
+
+	if p.ServicePort != "" || (p.Service != "" && p.Pod == "" && pfDetails.podSelection().isEmpty()) {
+		if p.ServiceNamespace != "" {
+			pfDetails.Namespace = p.ServiceNamespace
+		}
+
+		var (
+			resolution *serviceResolution
+			sel        podSelection
+		)
+
+		pod, resolution, sel, err = resolveServiceRequest(ctx, clientset, pfDetails.Namespace, p, strategy)
+		if err != nil {
+			return portForward{}, fmt.Errorf("failed to resolve service: %w", err)
+		}
+
+		pfDetails.ServiceResolution = resolution
+		pairs[0].TargetPort = resolution.ContainerPort
+		pfDetails.serviceSelector = sel.labels
+		pfDetails.PodSelectionStrategy = strategy
+		pfDetails.Pod = pod.Name
+		pfDetails.NodeName = pod.Spec.NodeName
+	} else if sel := pfDetails.podSelection(); !sel.isEmpty() {
+		sel.strategy = strategy
+
+		pod, err = selectPod(ctx, clientset, p.Namespace, p.Pod, sel)
+		if err != nil {
+			return portForward{}, fmt.Errorf("failed to resolve pod: %w", err)
+		}
+
+		pfDetails.PodSelectionStrategy = strategy
+		pfDetails.Pod = pod.Name
+		pfDetails.NodeName = pod.Spec.NodeName
+		pfDetails.Job = podJob(pod)
+	} else if p.TargetKind != "" {
+		owner, selector, err := workloadSelector(ctx, clientset, p.Namespace, p.TargetKind, p.TargetName)
+		if err != nil {
+			return portForward{}, fmt.Errorf("failed to resolve target workload: %w", err)
+		}
+
+		pod, err = resolvePod(ctx, clientset, p.Namespace, podSelection{labels: selector, strategy: strategy})
+		if err == nil && !isPodReady(pod) {
+			err = newError(ErrCodeNotFound, nil, "no ready pod of %s in namespace %s", owner, p.Namespace)
+		}
+
+		if err != nil {
+			return portForward{}, fmt.Errorf("failed to resolve pod: %w", err)
+		}
+
+		pfDetails.TargetKind = p.TargetKind
+		pfDetails.TargetName = p.TargetName
+		pfDetails.Owner = owner
+		pfDetails.Pod = pod.Name
+		pfDetails.NodeName = pod.Spec.NodeName
+		pfDetails.PodSelectionStrategy = strategy
+
+		// The port forward is only retargeted to the other pods of the workload when auto reconnecting.
+		if p.AutoReconnect {
+			pfDetails.AutoReconnect = true
+			pfDetails.ownerSelector = selector
+		}
+	} else if p.AutoReconnect {
+		pod, err = selectPod(ctx, clientset, p.Namespace, p.Pod, podSelection{})
+		if err != nil {
+			return portForward{}, fmt.Errorf("failed to resolve pod: %w", err)
+		}
+
+		owner, selector, err := podOwnerSelector(ctx, clientset, pod)
+		if err != nil {
+			return portForward{}, fmt.Errorf("failed to resolve pod owner: %w", err)
+		}
+
+		pfDetails.AutoReconnect = true
+		pfDetails.Owner = owner
+		pfDetails.ownerSelector = selector
+		pfDetails.PodSelectionStrategy = strategy
+		pfDetails.NodeName = pod.Spec.NodeName
+	} else if pod = findPod(ctx, clientset, p.Namespace, p.Pod); pod != nil {
+		pfDetails.NodeName = pod.Spec.NodeName
+	}
+
+	// Target port names are resolved to the numbers of the container ports.
+	for i, pair := range pairs {
+		if _, err := strconv.Atoi(pair.TargetPort); err == nil {
+			continue
+		}
+
+		if pod == nil {
+			if pod, err = getPod(ctx, clientset, pfDetails.Namespace, pfDetails.Pod); err != nil {
+				return portForward{}, fmt.Errorf("failed to resolve target port: %w", err)
+			}
+		}
+
+		targetPort, err := resolveTargetPort(pod, pfDetails.Container, pair.TargetPort)
+		if err != nil {
+			return portForward{}, fmt.Errorf("failed to resolve target port: %w", err)
+		}
+
+		pairs[i].TargetPortName = pair.TargetPort
+		pairs[i].TargetPort = targetPort
+	}
+
+	pfDetails.setPortPairs(pairs)
+
+	if p.VerifyTargetPort {
+		if pod == nil {
+			if pod, err = getPod(ctx, clientset, pfDetails.Namespace, pfDetails.Pod); err != nil {
+				return portForward{}, err
+			}
+		}
+
+		if err := verifyTargetPorts(pod, pfDetails.Container, pairs); err != nil {
+			return portForward{}, err
+		}
+	}
+
+	hostNetwork, err := checkHostNetwork(pod)
+	if err != nil {
+		return portForward{}, err
+	}
+
+	if hostNetwork {
+		pfDetails.HostNetwork = true
+		pfDetails.Warning = joinWarnings(pfDetails.Warning, hostNetworkWarning)
+	}
+
+	if p.DryRun {
+		if err := checkDryRun(ctx, clientset, pfDetails); err != nil {
+			return portForward{}, err
+		}
+
+		return *pfDetails, nil
+	}
+
+	if err := checkPodReady(readyCtx, clientset, pfDetails, pod, p.PodNotReady); err != nil {
+		return portForward{}, err
+	}
+
+	first, errInit := openTunnel(rConf, pfDetails, pfDetails.Pod, pfDetails.NodeName, pairs, p.DialHeaders)
+	if errInit != nil {
+		return portForward{}, newError(ErrCodeInternal, errInit, "failed to initialize port forwarder")
+	}
+
+	// The tunnel opened first is run, and new ones when retrying.
+	start := func() (*tunnel, error) {
+		t := first
+		first = nil
+
+		if t == nil {
+			var err error
+
+			t, err = openTunnel(rConf, pfDetails, pfDetails.Pod, pfDetails.NodeName, pairs, p.DialHeaders)
+			if err != nil {
+				return nil, newError(ErrCodeInternal, err, "failed to initialize port forwarder")
+			}
+		}
+
+		t.run()
+
+		return t, nil
+	}
+
+	opts := listenOptions{
+		reusePort:   p.ReusePort,
+		socket:      socketOptions,
+		idleTimeout: time.Duration(p.ConnectionIdleTimeoutSeconds) * time.Second,
+		onIdleReap:  func() { recordIdleReap(pfDetails) },
+		activity:    pfDetails.lastActivity,
+		traffic:     pfDetails.traffic,
+		limit:       newConnectionLimit(p.MaxConnections),
+	}
+	retarget := func() (*tunnel, error) {
+		return retargetPortForward(clientset, rConf, cache, pfDetails, p.DialHeaders)
+	}
+
+	// Starting a port forward again keeps track of its reconnections, its
+	// readiness counting as one.
+	previous, errPrevious := getPortForwardByID(cache, p.storedCluster(), p.ID)
+	if errPrevious == nil {
+		pfDetails.resumed = true
+		pfDetails.ReconnectCount = previous.ReconnectCount
+		pfDetails.LastReconnectAt = previous.LastReconnectAt
+
+		if !previous.CreatedAt.IsZero() {
+			pfDetails.CreatedAt = previous.CreatedAt
+		}
+
+		if previous.history != nil {
+			pfDetails.history = previous.history
+		}
+	}
+
+	t, ready, err := runAndMonitorPortForward(clientset, cache, pfDetails, start, opts, retarget)
+	if err != nil {
+		return portForward{}, err
+	}
+
+	if p.Probe != "" {
+		go probePortForward(pfDetails, p.Probe, t)
+	}
+
+	return ready, nil
+}
+
+// findPod returns the pod, for the checks of a port forward before its tunnel
+// is opened, or nil if it can't be got, which is left to the tunnel to report.
+func findPod(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) *corev1.Pod {
+	ctx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	defer cancel()
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"pod": name, "namespace": namespace}, err, "getting pod")
+
+		return nil
+	}
+
+	return pod
+}
+
+// checkPodReady checks the pod of the port forward is ready. If it isn't, it's
+// waited for up to the readiness timeout of the port forward with
+// PodNotReadyWait, and otherwise a warning is recorded on the port forward.
+// Failing to get the pod, nil, is left to the tunnel to report.
+func checkPodReady(ctx context.Context, clientset kubernetes.Interface, pfDetails *portForward, pod *corev1.Pod,
+	policy string,
+) error {
+	if pod == nil || isPodReady(pod) {
+		return nil
+	}
+
+	if policy != PodNotReadyWait {
+		pfDetails.Warning = joinWarnings(pfDetails.Warning,
+			fmt.Sprintf("pod %s/%s is not ready", pfDetails.Namespace, pfDetails.Pod))
+
+		return nil
+	}
+
+	timeout := pfDetails.readinessTimeout()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return newError(ErrCodeReadinessTimeout, ctx.Err(), "pod %s/%s not ready within %s",
+				pfDetails.Namespace, pfDetails.Pod, timeout)
+		case <-time.After(podReadyPollInterval):
+		}
+
+		pod, err := clientset.CoreV1().Pods(pfDetails.Namespace).Get(ctx, pfDetails.Pod, v1.GetOptions{})
+		if err != nil && ctx.Err() == nil {
+			logger.Log(logger.LevelWarn, pfDetails.logParams(map[string]string{
+				"pod": pfDetails.Pod, "namespace": pfDetails.Namespace,
+			}), err, "checking pod readiness")
+
+			return nil
+		}
+
+		if err == nil && isPodReady(pod) {
+			return nil
+		}
+	}
+}
+
+// hostNetworkWarning is the warning of the port forwards to pods on the host network.
+const hostNetworkWarning = "hostNetwork: the pod uses the host network, its ports are the ones of its node"
+
+// checkHostNetwork tells whether the pod is on the host network, where its
+// ports are the ones of its node, which may expose more than the pod. Such
+// pods are refused with HostNetworkReject, and the port forwards to them are
+// otherwise tagged with hostNetworkWarning. Failing to get the pod, nil, is
+// left to the tunnel to report.
+func checkHostNetwork(pod *corev1.Pod) (bool, error) {
+	if pod == nil || !pod.Spec.HostNetwork {
+		return false, nil
+	}
+
+	if HostNetworkPolicy == HostNetworkReject {
+		return false, newError(ErrCodeForbidden, nil,
+			"pod %s/%s uses the host network, port forwarding to it would reach the ports of node %s, "+
+				"which the portforward-host-network-policy config rejects",
+			pod.Namespace, pod.Name, pod.Spec.NodeName)
+	}
+
+	return true, nil
+}
+
+// errPodNotRunning is the error of the pod checks of pods which exist but
+// aren't running.
+var errPodNotRunning = errors.New("pod is not running")
+
+// errContainersRestarting is the error of the pod checks of pods which aren't
+// running as their containers restart in place.
+var errContainersRestarting = fmt.Errorf("%w, its containers are restarting", errPodNotRunning)
+
+// restartingInPlace tells whether the pod, not being deleted nor done, has
+// containers restarting, which it will run again after without being recreated.
+func restartingInPlace(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+		return false
+	}
+
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return false
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil && (status.RestartCount > 0 || status.State.Terminated != nil) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfPodIsRunning checks the pod is running, failing after apiRequestTimeout.
+func checkIfPodIsRunning(ctx context.Context, clientset kubernetes.Interface, namespace string, pod string) error {
+	ctx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+	defer cancel()
+
+	p, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod, v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if p.Status.Phase != corev1.PodRunning {
+		if restartingInPlace(p) {
+			return errContainersRestarting
+		}
+
+		return errPodNotRunning
+	}
+
+	return nil
+}
+
+// accessTarget is what a port forward touches in its namespace, to check the
+// user is allowed to.
+type accessTarget struct {
+	namespace string
+	// pod is the pod to port forward to, any pod of the namespace if empty.
+	pod string
+	// service is the service the pod is resolved from, if any.
+	service string
+}
+
+// resourceAttributes returns the actions the port forward needs to be allowed:
+// port forwarding to the pod, and getting the service and listing its
+// endpoint slices when resolving its pod.
+func (t accessTarget) resourceAttributes() []authorizationv1.ResourceAttributes {
+	attributes := []authorizationv1.ResourceAttributes{{
+		Namespace:   t.namespace,
+		Verb:        "create",
+		Resource:    "pods",
+		Subresource: "portforward",
+		Name:        t.pod,
+	}}
+
+	if t.service != "" {
+		attributes = append(attributes,
+			authorizationv1.ResourceAttributes{
+				Namespace: t.namespace,
+				Verb:      "get",
+				Resource:  "services",
+				Name:      t.service,
+			},
+			authorizationv1.ResourceAttributes{
+				Namespace: t.namespace,
+				Verb:      "list",
+				Group:     "discovery.k8s.io",
+				Resource:  "endpointslices",
+			})
+	}
+
+	return attributes
+}
+
+// describeAction describes the action of the resource attributes, e.g.
+// "create pods/portforward web".
+func describeAction(a authorizationv1.ResourceAttributes) string {
+	resource := a.Resource
+	if a.Group != "" {
+		resource += "." + a.Group
+	}
+
+	if a.Subresource != "" {
+		resource += "/" + a.Subresource
+	}
+
+	action := a.Verb + " " + resource
+	if a.Name != "" {
+		action += " " + a.Name
+	}
+
+	return action
+}
+
+// checkPortForwardPermission checks with SelfSubjectAccessReviews that the user
+// is allowed all the actions the port forward to the target needs, failing
+// with ErrCodePermissionCheckTimeout after PermissionCheckTimeout. The denied
+// actions are all listed in the error.
+func checkPortForwardPermission(ctx context.Context, clientset kubernetes.Interface, target accessTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, PermissionCheckTimeout)
+	defer cancel()
+
+	denied := []string{}
+
+	for _, attributes := range target.resourceAttributes() {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &attributes,
+			},
+		}
+
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, v1.CreateOptions{})
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return newError(ErrCodePermissionCheckTimeout, err, "permission check timed out after %s",
+				PermissionCheckTimeout)
+		}
+
+		if err != nil {
+			return newError(ErrCodeInternal, err, "checking port forward permission")
+		}
+
+		if !result.Status.Allowed {
+			reason := result.Status.Reason
+			if reason == "" {
+				reason = "no RBAC rule allows it"
+			}
+
+			denied = append(denied, fmt.Sprintf("%s (%s)", describeAction(attributes), reason))
+		}
+	}
+
+	if len(denied) > 0 {
+		return newError(ErrCodeForbidden, nil, "not allowed to port forward in namespace %s: %s",
+			target.namespace, strings.Join(denied, "; "))
+	}
+
+	return nil
+}
+
+// stopOrDeletePortForwardRequest is the payload for stop or delete port forward request handler.
+// Without an id, all the port forwards of the cluster to the namespace and pod are stopped or deleted.
+type stopOrDeletePortForwardRequest struct {
+	ID           string `json:"id"`
+	Cluster      string `json:"cluster"`
+	Namespace    string `json:"namespace"`
+	Pod          string `json:"pod"`
+	StopOrDelete bool   `json:"stopOrDelete"`
+	// DrainSeconds, when stopping, stops accepting local connections and
+	// waits up to this many seconds for the open ones to be closed before
+	// stopping the port forwards, at most MaxDrainSeconds.
+	DrainSeconds int `json:"drainSeconds,omitempty"`
+}
+
+func (r *stopOrDeletePortForwardRequest) Validate() error {
+	if r.ID == "" && (r.Namespace == "" || r.Pod == "") {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, id or namespace and pod are required")
+	}
+
+	if r.ID != "" && (r.Namespace != "" || r.Pod != "") {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, id can't be set along with namespace and pod")
+	}
+
+	if r.DrainSeconds < 0 || r.DrainSeconds > MaxDrainSeconds {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, drainSeconds must be between 0 and %d",
+			MaxDrainSeconds)
+	}
+
+	if r.DrainSeconds > 0 && !r.StopOrDelete {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, drainSeconds requires stopOrDelete")
+	}
+
+	if r.Cluster == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, cluster is required")
+	}
+
+	return nil
+}
+
+// portForwards returns the port forwards of the cluster the request stops or
+// deletes. Those which can't be listed are left to the stop to report.
+func (r *stopOrDeletePortForwardRequest) portForwards(cache cache.Cache[interface{}], cluster string) []portForward {
+	if r.ID != "" {
+		pf, err := getPortForwardByID(cache, cluster, r.ID)
+		if err != nil {
+			return nil
+		}
+
+		return []portForward{pf}
+	}
+
+	portForwards, err := getPortForwardList(cache, cluster)
+	if err != nil {
+		return nil
+	}
+
+	return portForwardFilter{namespace: r.Namespace, pod: r.Pod}.filter(portForwards)
 }
 
 // StopOrDeletePortForward handles stop or delete port forward request.
 func StopOrDeletePortForward(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
 	var p stopOrDeletePortForwardRequest
 
-	err := json.NewDecoder(r.Body).Decode(&p)
+	err := json.NewDecoder(r.Body).Decode(&p)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding delete portforward payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := p.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating delete portforward payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	clusterName := userClusterName(r, p.Cluster)
+
+	if p.DrainSeconds > 0 {
+		drainPortForwards(p.portForwards(cache, clusterName), time.Duration(p.DrainSeconds)*time.Second)
+	}
+
+	if p.ID == "" {
+		filter := portForwardFilter{namespace: p.Namespace, pod: p.Pod}
+		writeStopAllResult(cache, clusterName, filter, p.StopOrDelete, w)
+
+		return
+	}
+
+	err = stopOrDeletePortForward(cache, clusterName, p.ID, p.StopOrDelete)
+	if err == nil {
+		if _, err := w.Write([]byte("stopped")); err != nil {
+			logger.Log(logger.LevelError, nil, err, "writing response")
+			http.Error(w, "failed to write response "+err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	http.Error(w, "failed to delete port forward "+err.Error(), errorStatus(err))
+}
+
+// stopAllPortForwardsRequest is the payload of the stop all port forwards request handler.
+type stopAllPortForwardsRequest struct {
+	Cluster      string `json:"cluster"`
+	StopOrDelete bool   `json:"stopOrDelete"`
+}
+
+func (r *stopAllPortForwardsRequest) Validate() error {
+	if r.Cluster == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, cluster is required")
+	}
+
+	return nil
+}
+
+// stopAllFailure is a port forward which couldn't be stopped or deleted.
+type stopAllFailure struct {
+	ID    string    `json:"id"`
+	Code  ErrorCode `json:"code"`
+	Error string    `json:"error"`
+}
+
+// stopAllResult is the summary of stopping or deleting all the port forwards of a cluster.
+type stopAllResult struct {
+	// Stopped are the ids of the port forwards stopped, or deleted.
+	Stopped []string         `json:"stopped"`
+	Failed  []stopAllFailure `json:"failed"`
+}
+
+// stopAllPortForwards stops the port forwards of the cluster matching the filter
+// not stopped yet, or deletes all of them if isStopRequest is false, going on
+// when one fails.
+func stopAllPortForwards(cache cache.Cache[interface{}], cluster string, filter portForwardFilter,
+	isStopRequest bool,
+) (stopAllResult, error) {
+	result := stopAllResult{Stopped: []string{}, Failed: []stopAllFailure{}}
+
+	portForwards, err := getPortForwardList(cache, cluster)
+	if err != nil {
+		return result, err
+	}
+
+	for _, pf := range filter.filter(portForwards) {
+		if isStopRequest && pf.Status == STOPPED {
+			continue
+		}
+
+		if err := stopOrDeletePortForward(cache, cluster, pf.ID, isStopRequest); err != nil {
+			result.Failed = append(result.Failed, stopAllFailure{ID: pf.ID, Code: errorCode(err), Error: err.Error()})
+
+			continue
+		}
+
+		result.Stopped = append(result.Stopped, pf.ID)
+	}
+
+	return result, nil
+}
+
+// StopAllPortForwards handles the request stopping, or deleting, all the port
+// forwards of a cluster at once, e.g. when the user disconnects from it.
+func StopAllPortForwards(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	var p stopAllPortForwardsRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding stop all portforwards payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := p.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating stop all portforwards payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	writeStopAllResult(cache, userClusterName(r, p.Cluster), portForwardFilter{}, p.StopOrDelete, w)
+}
+
+// writeStopAllResult stops, or deletes, the port forwards of the cluster
+// matching the filter and writes the ids of the affected ones to w.
+func writeStopAllResult(cache cache.Cache[interface{}], cluster string, filter portForwardFilter,
+	isStopRequest bool, w http.ResponseWriter,
+) {
+	result, err := stopAllPortForwards(cache, cluster, filter, isStopRequest)
 	if err != nil {
-		logger.Log(logger.LevelError, nil, err, "decoding delete portforward payload")
+		logger.Log(logger.LevelError, map[string]string{"cluster": cluster}, err, "stopping portforwards")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// pausePortForwardRequest is the payload of the pause and resume port forward request handlers.
+type pausePortForwardRequest struct {
+	ID      string `json:"id"`
+	Cluster string `json:"cluster"`
+}
+
+func (r *pausePortForwardRequest) Validate() error {
+	if r.ID == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, id is required")
+	}
+
+	if r.Cluster == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, cluster is required")
+	}
+
+	return nil
+}
+
+// pausePortForward stops a running port forward, freeing its local ports,
+// but keeps it with the PAUSED status so it can be resumed.
+func pausePortForward(cache cache.Cache[interface{}], cluster string, id string) error {
+	pf, err := getPortForwardByID(cache, cluster, id)
+	if err != nil {
+		return err
+	}
+
+	if pf.Status != RUNNING {
+		return newError(ErrCodeStopped, nil, "portforward %s is not running", id)
+	}
+
+	pf.Status = PAUSED
+
+	if err := storePortForward(cache, pf); err != nil {
+		return err
+	}
+
+	safeCloseChan(pf.closeChan)
+
+	return nil
+}
+
+// resumePortForward starts a paused port forward again, with the request it
+// was started with, on the same local ports. It counts as a reconnection.
+func resumePortForward(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}], cluster string,
+	id string, r *http.Request,
+) (portForwardRequest, portForward, error) {
+	pf, err := getPortForwardByID(cache, cluster, id)
+	if err != nil {
+		return portForwardRequest{}, portForward{}, err
+	}
+
+	if pf.Status != PAUSED {
+		return portForwardRequest{}, portForward{}, newError(ErrCodeStopped, nil, "portforward %s is not paused", id)
+	}
+
+	if pf.request == nil {
+		return portForwardRequest{}, portForward{}, newError(ErrCodeInternal, nil,
+			"portforward %s can't be resumed, its request wasn't kept", id)
+	}
+
+	p := *pf.request
+	p.Ports = append([]PortPair(nil), p.Ports...)
+	// It's the paused port forward which is started again, not any other
+	// running duplicate of it.
+	p.reuseRunning = false
+	// It may have been renamed since.
+	p.Name = pf.Name
+	p.setPorts(pf)
+
+	started, err := startPortForwardRequest(kubeConfigStore, cache, &p, r)
+
+	return p, started, err
+}
+
+// PausePortForward handles the request pausing a port forward.
+func PausePortForward(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	var p pausePortForwardRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding pause portforward payload")
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
 		return
 	}
 
 	if err := p.Validate(); err != nil {
-		logger.Log(logger.LevelError, nil, err, "validating delete portforward payload")
+		logger.Log(logger.LevelError, nil, err, "validating pause portforward payload")
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
 		return
 	}
 
-	userID := r.Header.Get("X-HEADLAMP-USER-ID")
-	clusterName := p.Cluster
+	if err := pausePortForward(cache, userClusterName(r, p.Cluster), p.ID); err != nil {
+		logger.Log(logger.LevelError, map[string]string{"id": p.ID}, err, "pausing portforward")
+		http.Error(w, err.Error(), errorStatus(err))
 
-	if userID != "" {
-		clusterName = p.Cluster + userID
+		return
 	}
 
-	err = stopOrDeletePortForward(cache, clusterName, p.ID, p.StopOrDelete)
-	if err == nil {
-		if _, err := w.Write([]byte("stopped")); err != nil {
-			logger.Log(logger.LevelError, nil, err, "writing response")
-			http.Error(w, "failed to write response "+err.Error(), http.StatusInternalServerError)
-		}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResumePortForward handles the request resuming a paused port forward,
+// responding with its request as StartPortForward does.
+func ResumePortForward(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}],
+	w http.ResponseWriter, r *http.Request,
+) {
+	var p pausePortForwardRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding resume portforward payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := p.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating resume portforward payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	request, pf, err := resumePortForward(kubeConfigStore, cache, userClusterName(r, p.Cluster), p.ID, r)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"id": p.ID}, err, "resuming portforward")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	request.setBound(pf)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(request); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// setPortForwardMonitorRequest is the payload of the set port forward monitor request handler.
+type setPortForwardMonitorRequest struct {
+	ID       string `json:"id"`
+	Cluster  string `json:"cluster"`
+	Disabled bool   `json:"disabled"`
+}
+
+func (r *setPortForwardMonitorRequest) Validate() error {
+	if r.ID == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, id is required")
+	}
+
+	if r.Cluster == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, cluster is required")
+	}
+
+	return nil
+}
+
+// setPortForwardMonitor disables or enables the pod monitor of a running port forward.
+func setPortForwardMonitor(cache cache.Cache[interface{}], cluster string, id string, disabled bool) error {
+	pf, err := getPortForwardByID(cache, cluster, id)
+	if err != nil {
+		return err
+	}
+
+	if pf.Status != RUNNING || pf.monitorDisabled == nil {
+		return newError(ErrCodeStopped, nil, "portforward %s is not running", id)
+	}
+
+	pf.monitorDisabled.Store(disabled)
+	pf.MonitorDisabled = disabled
+
+	// The pod monitor doesn't check the pod while disabled.
+	if !disabled && pf.lastPodCheck != nil {
+		pf.lastPodCheck.Store(time.Now().UnixNano())
+	}
+
+	return storePortForward(cache, pf)
+}
+
+// SetPortForwardMonitor handles the request disabling or enabling the pod
+// monitor of a port forward, which keeps running whatever happens to its pod
+// while its monitor is disabled.
+func SetPortForwardMonitor(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	var p setPortForwardMonitorRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding portforward monitor payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := p.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating portforward monitor payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := setPortForwardMonitor(cache, userClusterName(r, p.Cluster), p.ID, p.Disabled); err != nil {
+		logger.Log(logger.LevelError, map[string]string{"id": p.ID}, err, "setting portforward monitor")
+		http.Error(w, err.Error(), errorStatus(err))
 
 		return
 	}
 
-	http.Error(w, "failed to delete port forward "+err.Error(), http.StatusInternalServerError)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// portForwardList is the response of GetPortForwards.
+type portForwardList struct {
+	Items []portForward `json:"items"`
+	Total int           `json:"total"`
 }
 
-// GetPortForwards handles get port forwards request.
+// GetPortForwards handles get port forwards request. The port forwards are
+// sorted by start time then id, and returned with their total in a
+// portForwardList, or as a bare array when the format query param is "array",
+// the response of older versions. The namespace, pod and status query params
+// filter the port forwards, the latter taking a comma separated list.
 func GetPortForwards(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
 	cluster := r.URL.Query().Get("cluster")
 	if cluster == "" {
@@ -542,18 +3126,158 @@ func GetPortForwards(cache cache.Cache[interface{}], w http.ResponseWriter, r *h
 		return
 	}
 
-	userID := r.Header.Get("X-HEADLAMP-USER-ID")
-	clusterName := cluster
+	clusterName := userClusterName(r, cluster)
 
-	if userID != "" {
-		clusterName = cluster + userID
+	ports, err := getPortForwardList(cache, clusterName)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	ports = newPortForwardFilter(r.URL.Query()).filter(ports)
+
+	var payload interface{} = portForwardList{Items: ports, Total: len(ports)}
+	if r.URL.Query().Get("format") == "array" {
+		payload = ports
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// portForwardSummary are the counts of the port forwards of a cluster by status.
+type portForwardSummary struct {
+	Running      int `json:"running"`
+	Reconnecting int `json:"reconnecting"`
+	Stopped      int `json:"stopped"`
+	Paused       int `json:"paused"`
+	Total        int `json:"total"`
+}
+
+// userPortForwardList is the response of GetUserPortForwards: the port
+// forwards of a user by cluster, and their total.
+type userPortForwardList struct {
+	Clusters map[string]portForwardList `json:"clusters"`
+	Total    int                        `json:"total"`
+}
+
+// GetUserPortForwards handles the request listing the port forwards of the
+// user of the X-HEADLAMP-USER-ID header across all the clusters, grouped by
+// cluster and sorted as by GetPortForwards. The port forwards started without
+// a user id are listed if it's not set. The namespace, pod and status query
+// params filter the port forwards as for GetPortForwards, the clusters without
+// any left being omitted.
+func GetUserPortForwards(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	clusters, err := getUserPortForwards(cache, r.Header.Get("X-HEADLAMP-USER-ID"))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	filter := newPortForwardFilter(r.URL.Query())
+	payload := userPortForwardList{Clusters: map[string]portForwardList{}}
+
+	for cluster, ports := range clusters {
+		ports = filter.filter(ports)
+		if len(ports) == 0 {
+			continue
+		}
+
+		payload.Clusters[cluster] = portForwardList{Items: ports, Total: len(ports)}
+		payload.Total += len(ports)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// summarizePortForwards counts the port forwards by status.
+func summarizePortForwards(ports []portForward) portForwardSummary {
+	summary := portForwardSummary{Total: len(ports)}
+
+	for _, p := range ports {
+		switch p.Status {
+		case RUNNING:
+			summary.Running++
+		case RECONNECTING:
+			summary.Reconnecting++
+		case STOPPED:
+			summary.Stopped++
+		case PAUSED:
+			summary.Paused++
+		}
+	}
+
+	return summary
+}
+
+// GetPortForwardSummary handles the request for the counts of the port
+// forwards of a cluster by status, e.g. for a badge polling them without
+// fetching the whole list. The query params filter the port forwards as for
+// GetPortForwards.
+func GetPortForwardSummary(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	cluster := r.URL.Query().Get("cluster")
+	if cluster == "" {
+		logger.Log(logger.LevelError, nil, errors.New("cluster is required"), "getting portforward summary")
+		http.Error(w, "cluster is required", http.StatusBadRequest)
+
+		return
+	}
+
+	ports, err := getPortForwardList(cache, userClusterName(r, cluster))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	summary := summarizePortForwards(newPortForwardFilter(r.URL.Query()).filter(ports))
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+	}
+}
+
+// GetPortForwardTargets handles get port forward targets request, listing
+// the pods and ports being forwarded in a cluster with their number of port forwards.
+func GetPortForwardTargets(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	cluster := r.URL.Query().Get("cluster")
+	if cluster == "" {
+		logger.Log(logger.LevelError, nil, errors.New("cluster is required"), "getting portforward targets")
+		http.Error(w, "cluster is required", http.StatusBadRequest)
+
+		return
 	}
 
-	ports := getPortForwardList(cache, clusterName)
+	clusterName := userClusterName(r, cluster)
+
+	targets, err := getPortForwardTargets(cache, clusterName)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"cluster": cluster}, err, "getting portforward targets")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	if err := json.NewEncoder(w).Encode(ports); err != nil {
+	if err := json.NewEncoder(w).Encode(targets); err != nil {
 		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
 		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
 
@@ -561,7 +3285,49 @@ func GetPortForwards(cache cache.Cache[interface{}], w http.ResponseWriter, r *h
 	}
 }
 
+// diagnostics are the details of a port forward useful to debug it,
+// returned by GetPortForwardByID when the verbose query param is true.
+type diagnostics struct {
+	Status          string   `json:"status"`
+	Error           string   `json:"error,omitempty"`
+	Protocol        string   `json:"protocol,omitempty"`
+	ForwarderOutput []string `json:"forwarderOutput,omitempty"`
+	Addresses       []string `json:"addresses,omitempty"`
+	StreamLimitHits int      `json:"streamLimitHits"`
+	LastStreamError string   `json:"lastStreamError,omitempty"`
+	LastPodCheck    string   `json:"lastPodCheck,omitempty"`
+	PodTemplateHash string   `json:"podTemplateHash,omitempty"`
+	PodSelection    string   `json:"podSelection,omitempty"`
+	MonitorDisabled bool     `json:"monitorDisabled"`
+}
+
+// getDiagnostics returns the diagnostics of the port forward.
+func getDiagnostics(p portForward) *diagnostics {
+	d := &diagnostics{
+		Status:          p.Status,
+		Error:           p.Error,
+		Protocol:        p.Protocol,
+		ForwarderOutput: p.ForwarderOutput,
+		Addresses:       p.Addresses,
+		StreamLimitHits: p.StreamLimitHits,
+		LastStreamError: p.LastStreamError,
+		PodTemplateHash: p.PodTemplateHash,
+		PodSelection:    p.podSelection().String(),
+		MonitorDisabled: p.MonitorDisabled,
+	}
+
+	if p.lastPodCheck != nil {
+		d.LastPodCheck = time.Unix(0, p.lastPodCheck.Load()).UTC().Format(time.RFC3339)
+	}
+
+	return d
+}
+
 // GetPortForwardByID handles get port forward by id request.
+// Port forwards which aren't running are returned too, with their status and
+// error, only unknown ids responding with a 404.
+// For HEAD requests it only reports the status in the StatusHeader header.
+// With the verbose query param set to true, it includes the diagnostics.
 func GetPortForwardByID(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
 	cluster := r.URL.Query().Get("cluster")
 	if cluster == "" {
@@ -579,35 +3345,113 @@ func GetPortForwardByID(cache cache.Cache[interface{}], w http.ResponseWriter, r
 		return
 	}
 
-	userID := r.Header.Get("X-HEADLAMP-USER-ID")
-	clusterName := cluster
-
-	if userID != "" {
-		clusterName = cluster + userID
-	}
+	clusterName := userClusterName(r, cluster)
 
 	p, err := getPortForwardByID(cache, clusterName, id)
 	if err != nil {
 		logger.Log(logger.LevelError, nil, err, "getting portforward by id")
-		http.Error(w, "no portforward running with id "+id, http.StatusNotFound)
+
+		message := "no portforward with id " + id
+		if errorCode(err) != ErrCodeNotFound {
+			message = err.Error()
+		}
+
+		http.Error(w, message, errorStatus(err))
+
+		return
+	}
+
+	// HEAD requests are used as a cheap liveness check, so only the status
+	// is reported through a header and the payload is skipped.
+	if r.Method == http.MethodHead {
+		w.Header().Set(StatusHeader, p.Status)
+		w.WriteHeader(http.StatusOK)
 
 		return
 	}
 
 	type payload struct {
-		ID        string `json:"id"`
-		Pod       string `json:"pod"`
-		Service   string `json:"service"`
-		Cluster   string `json:"cluster"`
-		Namespace string `json:"namespace"`
+		ID                   string             `json:"id"`
+		Name                 string             `json:"name,omitempty"`
+		Pod                  string             `json:"pod"`
+		Service              string             `json:"service"`
+		Cluster              string             `json:"cluster"`
+		ServerURL            string             `json:"serverURL,omitempty"`
+		Namespace            string             `json:"namespace"`
+		Status               string             `json:"status"`
+		Error                string             `json:"error,omitempty"`
+		Warning              string             `json:"warning,omitempty"`
+		HostNetwork          bool               `json:"hostNetwork,omitempty"`
+		NodeName             string             `json:"nodeName,omitempty"`
+		CronJob              string             `json:"cronJob,omitempty"`
+		Job                  string             `json:"job,omitempty"`
+		LabelSelector        string             `json:"labelSelector,omitempty"`
+		PodSelectionStrategy string             `json:"podSelectionStrategy,omitempty"`
+		TargetPort           string             `json:"targetPort"`
+		Ports                []PortPair         `json:"ports,omitempty"`
+		ServiceResolution    *serviceResolution `json:"serviceResolution,omitempty"`
+		CreatedAt            time.Time          `json:"createdAt"`
+		ReadyAt              *time.Time         `json:"readyAt,omitempty"`
+		StoppedAt            *time.Time         `json:"stoppedAt,omitempty"`
+		ReconnectCount       int                `json:"reconnectCount"`
+		LastReconnectAt      *time.Time         `json:"lastReconnectAt,omitempty"`
+		ProbeResult          *probeResult       `json:"probeResult,omitempty"`
+		SocketOptions        *socketOptions     `json:"socketOptions,omitempty"`
+		BytesIn              int64              `json:"bytesIn"`
+		BytesOut             int64              `json:"bytesOut"`
+		ActiveConnections    int64              `json:"activeConnections"`
+		PeakConnections      int64              `json:"peakConnections"`
+		TotalConnections     int64              `json:"totalConnections"`
+		RejectedConnections  int64              `json:"rejectedConnections"`
+		APILatencyMs         float64            `json:"apiLatencyMs,omitempty"`
+		Diagnostics          *diagnostics       `json:"diagnostics,omitempty"`
+		Events               []historyEvent     `json:"events,omitempty"`
 	}
 
 	portForwardStruct := payload{
-		ID:        p.ID,
-		Pod:       p.Pod,
-		Namespace: p.Namespace,
-		Cluster:   p.Cluster,
-		Service:   p.Service,
+		ID:                   p.ID,
+		Name:                 p.Name,
+		Pod:                  p.Pod,
+		Namespace:            p.Namespace,
+		Cluster:              p.ClusterName,
+		ServerURL:            p.ServerURL,
+		Service:              p.Service,
+		Status:               p.Status,
+		Error:                p.Error,
+		Warning:              p.Warning,
+		HostNetwork:          p.HostNetwork,
+		NodeName:             p.NodeName,
+		CronJob:              p.CronJob,
+		Job:                  p.Job,
+		LabelSelector:        p.LabelSelector,
+		PodSelectionStrategy: p.PodSelectionStrategy,
+		TargetPort:           p.TargetPort,
+		Ports:                p.Ports,
+		ServiceResolution:    p.ServiceResolution,
+		CreatedAt:            p.CreatedAt,
+		ReadyAt:              p.ReadyAt,
+		StoppedAt:            p.StoppedAt,
+		ReconnectCount:       p.ReconnectCount,
+		LastReconnectAt:      p.LastReconnectAt,
+		ProbeResult:          p.ProbeResult,
+		SocketOptions:        p.SocketOptions,
+		Events:               p.events(),
+	}
+
+	if p.traffic != nil {
+		portForwardStruct.BytesIn = p.traffic.bytesIn.Load()
+		portForwardStruct.BytesOut = p.traffic.bytesOut.Load()
+		portForwardStruct.ActiveConnections = p.traffic.activeConnections.Load()
+		portForwardStruct.PeakConnections, portForwardStruct.TotalConnections = p.traffic.connectionCounts()
+		portForwardStruct.RejectedConnections = p.traffic.rejectedConnections.Load()
+	}
+
+	if p.apiLatency != nil {
+		portForwardStruct.APILatencyMs = p.apiLatency.milliseconds()
+	}
+
+	if r.URL.Query().Get("verbose") == "true" {
+		portForwardStruct.Diagnostics = getDiagnostics(p)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
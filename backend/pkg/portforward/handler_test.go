@@ -236,10 +236,16 @@ func TestStartPortForward(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, listData)
 
-	var pfListRespPayload []map[string]interface{}
-	err = json.Unmarshal(listData, &pfListRespPayload)
+	var pfListResp struct {
+		Items []map[string]interface{} `json:"items"`
+		Total int                      `json:"total"`
+	}
+	err = json.Unmarshal(listData, &pfListResp)
 	require.NoError(t, err)
-	assert.NotEmpty(t, pfListRespPayload)
+	assert.NotEmpty(t, pfListResp.Items)
+	assert.Equal(t, len(pfListResp.Items), pfListResp.Total)
+
+	pfListRespPayload := pfListResp.Items
 
 	foundInList := false // Line 261: This assignment is now un-cuddled if necessary
 
@@ -0,0 +1,111 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const redactedHeaderValue = "REDACTED"
+
+// reservedDialHeaders are the headers of the upgrade request which are set
+// by the SPDY dialer and can't be overridden.
+var reservedDialHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Host",
+	"Transfer-Encoding",
+	"Upgrade",
+	"X-Stream-Protocol-Version",
+}
+
+// sensitiveHeaderWords are the words making a header value redacted in logs
+// when found in its name.
+var sensitiveHeaderWords = []string{"authorization", "cookie", "token", "secret", "password", "key"}
+
+// validateDialHeaders checks the dial headers are valid and don't override
+// the headers of the upgrade request.
+func validateDialHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return newError(ErrCodeInvalidRequest, nil, "invalid dial header name %q", name)
+		}
+
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return newError(ErrCodeInvalidRequest, nil, "invalid value for dial header %q", name)
+		}
+
+		for _, reserved := range reservedDialHeaders {
+			if strings.EqualFold(name, reserved) {
+				return newError(ErrCodeInvalidRequest, nil, "dial header %q is reserved", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isSensitiveHeader tells whether the value of the header shouldn't be logged.
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactHeaders returns the headers formatted for logs, with the values of the
+// sensitive ones redacted.
+func redactHeaders(headers map[string]string) string {
+	redacted := make(http.Header, len(headers))
+
+	for name, value := range headers {
+		if isSensitiveHeader(name) {
+			value = redactedHeaderValue
+		}
+
+		redacted.Set(name, value)
+	}
+
+	return fmt.Sprint(redacted)
+}
+
+// headerRoundTripper is a http.RoundTripper adding headers to the requests,
+// used to add the dial headers to the upgrade request of the port forward.
+type headerRoundTripper struct {
+	http.RoundTripper
+	headers map[string]string
+}
+
+// RoundTrip adds the headers to a copy of the request and sends it.
+func (rt *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	for name, value := range rt.headers {
+		req.Header.Set(name, value)
+	}
+
+	return rt.RoundTripper.RoundTrip(req)
+}
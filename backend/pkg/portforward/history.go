@@ -0,0 +1,101 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"sync"
+	"time"
+)
+
+// maxHistoryEvents is the number of events kept in the history of a port
+// forward, the oldest ones being dropped first.
+const maxHistoryEvents = 20
+
+// The reasons of the events of the history of a port forward.
+const (
+	eventRunning       = "Running"
+	eventPodCheckRetry = "PodCheckRetry"
+	eventPodLost       = "PodLost"
+	eventReconnecting  = "Reconnecting"
+	eventRetargeted    = "Retargeted"
+	eventRebound       = "Rebound"
+	eventPaused        = "Paused"
+	eventDraining      = "Draining"
+	eventStopped       = "Stopped"
+	eventFailed        = "Failed"
+)
+
+// historyEvent is an event of the lifetime of a port forward.
+type historyEvent struct {
+	Time    time.Time `json:"time"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message,omitempty"`
+}
+
+// eventHistory is a ring buffer of the last events of a port forward. It is
+// shared by the copies of the port forward and written concurrently by its
+// monitor and supervisor.
+type eventHistory struct {
+	mu     sync.Mutex
+	events []historyEvent
+	// next is the index the next event is written at, once the buffer is full.
+	next int
+}
+
+// add records an event, dropping the oldest one if the history is full.
+func (h *eventHistory) add(reason string, message string) {
+	event := historyEvent{Time: time.Now(), Reason: reason, Message: message}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.events) < maxHistoryEvents {
+		h.events = append(h.events, event)
+
+		return
+	}
+
+	h.events[h.next] = event
+	h.next = (h.next + 1) % maxHistoryEvents
+}
+
+// list returns the events, oldest first.
+func (h *eventHistory) list() []historyEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make([]historyEvent, 0, len(h.events))
+	events = append(events, h.events[h.next:]...)
+
+	return append(events, h.events[:h.next]...)
+}
+
+// recordEvent adds an event to the history of the port forward, if it has one.
+func (p *portForward) recordEvent(reason string, message string) {
+	if p.history != nil {
+		p.history.add(reason, message)
+	}
+}
+
+// events returns the history of the port forward, oldest first.
+func (p *portForward) events() []historyEvent {
+	if p.history == nil {
+		return nil
+	}
+
+	return p.history.list()
+}
@@ -0,0 +1,782 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// forwarderAddress is the address the underlying port forwarder listens on.
+// Local connections are accepted by a localListener and proxied to it.
+const forwarderAddress = "127.0.0.1"
+
+const (
+	// minSocketBufferBytes and maxSocketBufferBytes bound the buffer sizes
+	// of the local connections.
+	minSocketBufferBytes = 4 * 1024
+	maxSocketBufferBytes = 16 * 1024 * 1024
+)
+
+// socketOptions are the socket options of the accepted local connections.
+type socketOptions struct {
+	// NoDelay sets TCP_NODELAY, sending small writes right away.
+	NoDelay bool `json:"noDelay"`
+	// ReadBufferBytes and WriteBufferBytes are the SO_RCVBUF and SO_SNDBUF
+	// sizes, the system defaults if 0. The kernel may adjust them, e.g.
+	// Linux doubles them for its bookkeeping.
+	ReadBufferBytes  int `json:"readBufferBytes,omitempty"`
+	WriteBufferBytes int `json:"writeBufferBytes,omitempty"`
+}
+
+// listenOptions are the socket options of the local listener.
+type listenOptions struct {
+	// reusePort sets SO_REUSEPORT, letting other sockets bind the same port.
+	reusePort bool
+	// socket are the options of the accepted connections.
+	socket socketOptions
+	// idleTimeout, if set, closes the connections without traffic in either
+	// direction for that long, calling onIdleReap.
+	idleTimeout time.Duration
+	onIdleReap  func()
+	// activity, if set, is updated with the unix time in nanoseconds of the
+	// last connection accepted or data received from either side.
+	activity *atomic.Int64
+	// traffic, if set, counts the connections and the data forwarded.
+	traffic *trafficStats
+	// limit, if set, bounds the connections proxied at once, the ones over
+	// it being refused. It is shared by the listeners of a port forward.
+	limit *connectionLimit
+}
+
+// connectionLimit bounds the connections proxied at once by the local
+// listeners of a port forward, so a burst of connections doesn't overload its pod.
+type connectionLimit struct {
+	slots chan struct{}
+}
+
+// newConnectionLimit returns a limit of size connections, none if size isn't positive.
+func newConnectionLimit(size int) *connectionLimit {
+	if size <= 0 {
+		return nil
+	}
+
+	return &connectionLimit{slots: make(chan struct{}, size)}
+}
+
+// acquire takes a slot for a connection, telling whether one was free.
+func (c *connectionLimit) acquire() bool {
+	if c == nil {
+		return true
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees the slot of a connection once closed.
+func (c *connectionLimit) release() {
+	if c != nil {
+		<-c.slots
+	}
+}
+
+// trafficStats are the traffic counters of a port forward, updated by the
+// connection handlers and read concurrently.
+type trafficStats struct {
+	// bytesIn are the bytes received from the local connections, bytesOut
+	// the ones sent back to them.
+	bytesIn           atomic.Int64
+	bytesOut          atomic.Int64
+	activeConnections atomic.Int64
+	// rejectedConnections are the local connections refused as the
+	// connection limit of the port forward was reached.
+	rejectedConnections atomic.Int64
+	// streamLimitHits are the local connections not forwarded as the SPDY
+	// stream limit was reached, lastStreamError the last error creating a
+	// stream for one.
+	streamLimitHits atomic.Int64
+	lastStreamError atomic.Pointer[string]
+	// idleConnectionsReaped are the local connections closed for being idle.
+	idleConnectionsReaped atomic.Int64
+	// mu guards the connection counters over the lifetime of the port
+	// forward: the most connections open at once, and all the connections made.
+	mu               sync.Mutex
+	peakConnections  int64
+	totalConnections int64
+}
+
+// connectionOpened accounts for a local connection being proxied.
+func (s *trafficStats) connectionOpened() {
+	active := s.activeConnections.Add(1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totalConnections++
+	s.peakConnections = max(s.peakConnections, active)
+}
+
+// connectionClosed accounts for a proxied local connection being closed.
+func (s *trafficStats) connectionClosed() {
+	s.activeConnections.Add(-1)
+}
+
+// connectionCounts returns the most connections open at once and the number
+// of connections made.
+func (s *trafficStats) connectionCounts() (peak int64, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peakConnections, s.totalConnections
+}
+
+// localListener accepts the connections on the local addresses of a port
+// forward and proxies them to the port the port forwarder listens on.
+// Owning the listening sockets, rather than letting the port forwarder
+// create them, is what allows setting their socket options.
+type localListener struct {
+	listeners []net.Listener
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	target    string
+	opts      listenOptions
+	// closed tells whether the listener was closed, after which it isn't rebound.
+	closed bool
+	// probes are the local addresses of the liveness check connections, to
+	// the channel closed once they are accepted. They aren't proxied.
+	probes sync.Map
+	// conns are the accepted connections not closed yet.
+	conns sync.WaitGroup
+}
+
+// listenAddress is a local address to listen on, and whether failing to
+// listen on it is fatal.
+type listenAddress struct {
+	network  string
+	address  string
+	optional bool
+}
+
+// listenAddresses expands "localhost" into its IPv4 and IPv6 loopback
+// addresses, the IPv6 one being optional as it might not be available.
+func listenAddresses(addresses []string) []listenAddress {
+	if len(addresses) == 0 {
+		addresses = []string{"localhost"}
+	}
+
+	listenAddrs := []listenAddress{}
+
+	for _, address := range addresses {
+		switch ip := net.ParseIP(address); {
+		case address == "localhost":
+			listenAddrs = append(listenAddrs,
+				listenAddress{network: "tcp4", address: "127.0.0.1"},
+				listenAddress{network: "tcp6", address: "::1", optional: true},
+			)
+		case ip != nil && ip.To4() != nil:
+			listenAddrs = append(listenAddrs, listenAddress{network: "tcp4", address: address})
+		default:
+			listenAddrs = append(listenAddrs, listenAddress{network: "tcp6", address: address})
+		}
+	}
+
+	return listenAddrs
+}
+
+// interfaceAddress returns the first IPv4 address of the network interface,
+// which must be up.
+func interfaceAddress(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", newError(ErrCodeInvalidRequest, err, "unknown network interface %q", name)
+	}
+
+	if iface.Flags&net.FlagUp == 0 {
+		return "", newError(ErrCodeInvalidRequest, nil, "network interface %q is down", name)
+	}
+
+	addresses, err := iface.Addrs()
+	if err != nil {
+		return "", newError(ErrCodeInternal, err, "getting the addresses of network interface %q", name)
+	}
+
+	for _, address := range addresses {
+		if ipNet, ok := address.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4().String(), nil
+		}
+	}
+
+	return "", newError(ErrCodeInvalidRequest, nil, "network interface %q has no IPv4 address", name)
+}
+
+// listenLocal listens on port on each of the addresses and proxies the
+// accepted connections to target. If port is "0", the port picked for the
+// first address is used for the other ones.
+func listenLocal(addresses []string, port string, target string, opts listenOptions) (*localListener, error) {
+	l := &localListener{target: target, opts: opts}
+
+	listeners, err := l.bind(addresses, port)
+	if err != nil {
+		return nil, err
+	}
+
+	l.accept(listeners)
+
+	return l, nil
+}
+
+// bind listens on port on each of the addresses, without accepting the
+// connections yet. If port is "0", the port picked for the first address is
+// used for the other ones.
+func (l *localListener) bind(addresses []string, port string) ([]net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			return setListenerSockopts(conn, l.opts)
+		},
+	}
+
+	listeners := []net.Listener{}
+
+	for _, addr := range listenAddresses(addresses) {
+		listener, err := lc.Listen(context.Background(), addr.network, net.JoinHostPort(addr.address, port))
+		if err != nil {
+			if addr.optional {
+				continue
+			}
+
+			closeListeners(listeners)
+
+			return nil, newError(ErrCodePortUnavailable, err, "unable to listen on %s", net.JoinHostPort(addr.address, port))
+		}
+
+		port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// accept makes listeners the ones of l, and starts accepting their connections.
+func (l *localListener) accept(listeners []net.Listener) {
+	l.listeners = listeners
+
+	for _, listener := range listeners {
+		l.wg.Add(1)
+
+		go l.acceptConnections(listener)
+	}
+}
+
+// rebind moves the listener to port on the addresses. The new port is listened
+// on before the previous one is closed, so the listener is left as it was if
+// the port is unavailable. The connections already accepted are kept.
+func (l *localListener) rebind(addresses []string, port string) error {
+	listeners, err := l.bind(addresses, port)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+
+	if l.closed {
+		l.mu.Unlock()
+		closeListeners(listeners)
+
+		return newError(ErrCodeStopped, nil, "local listener is closed")
+	}
+
+	previous := l.listeners
+	l.accept(listeners)
+	l.mu.Unlock()
+
+	closeListeners(previous)
+
+	return nil
+}
+
+// current returns the listeners the connections are accepted on.
+func (l *localListener) current() []net.Listener {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.listeners
+}
+
+// closeListeners closes the listeners, logging the errors.
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Log(logger.LevelError, map[string]string{"address": listener.Addr().String()},
+				err, "closing local listener")
+		}
+	}
+}
+
+// localListeners are the local listeners of the port pairs of a port forward, in their order.
+type localListeners []*localListener
+
+// listenLocalPorts listens on the local port of each of the port pairs on the
+// addresses, proxying the accepted connections to the target of the same index.
+// A free port is picked for the pairs without a local port.
+func listenLocalPorts(addresses []string, ports []PortPair, targets []string,
+	opts listenOptions,
+) (localListeners, error) {
+	ls := make(localListeners, 0, len(ports))
+
+	for i, pair := range ports {
+		var (
+			l   *localListener
+			err error
+		)
+
+		if pair.Port == "" {
+			l, err = listenFreePort(addresses, targets[i], opts)
+		} else {
+			l, err = listenLocal(addresses, pair.Port, targets[i], opts)
+		}
+
+		if err != nil {
+			ls.Close()
+
+			return nil, err
+		}
+
+		ls = append(ls, l)
+	}
+
+	return ls, nil
+}
+
+// bridgePorts returns listeners which don't listen on any local port, for the
+// connections bridged from elsewhere, e.g. WebSockets, to be served to the
+// target of the same index.
+func bridgePorts(targets []string, opts listenOptions) localListeners {
+	ls := make(localListeners, 0, len(targets))
+
+	for _, target := range targets {
+		ls = append(ls, &localListener{target: target, opts: opts})
+	}
+
+	return ls
+}
+
+// listenFreePort listens on a free port, within PortRangeMin and PortRangeMax
+// if set, trying the ports of the range in order.
+func listenFreePort(addresses []string, target string, opts listenOptions) (*localListener, error) {
+	if PortRangeMax == 0 {
+		return listenLocal(addresses, "0", target, opts)
+	}
+
+	for port := PortRangeMin; port <= PortRangeMax; port++ {
+		l, err := listenLocal(addresses, strconv.Itoa(port), target, opts)
+		if !isAddrInUse(err) {
+			return l, err
+		}
+	}
+
+	return nil, newError(ErrCodePortUnavailable, nil, "no free local port in range %d-%d", PortRangeMin, PortRangeMax)
+}
+
+// setTargets changes the addresses the new connections are proxied to.
+func (ls localListeners) setTargets(targets []string) {
+	for i, l := range ls {
+		l.setTarget(targets[i])
+	}
+}
+
+// Close closes all the listeners.
+func (ls localListeners) Close() {
+	for _, l := range ls {
+		l.Close()
+	}
+}
+
+// drain stops accepting connections and waits up to timeout for the accepted
+// ones to be closed, telling whether they all were.
+func (ls localListeners) drain(timeout time.Duration) bool {
+	ls.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		for _, l := range ls {
+			l.conns.Wait()
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// setTarget changes the address the new connections are proxied to.
+func (l *localListener) setTarget(target string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.target = target
+}
+
+// getTarget returns the address the connections are proxied to.
+func (l *localListener) getTarget() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.target
+}
+
+// Port returns the local port listened on, empty if it doesn't listen on any.
+func (l *localListener) Port() string {
+	listeners := l.current()
+	if len(listeners) == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(listeners[0].Addr().(*net.TCPAddr).Port)
+}
+
+// Addresses returns the local addresses listened on.
+func (l *localListener) Addresses() []string {
+	listeners := l.current()
+	addresses := make([]string, 0, len(listeners))
+
+	for _, listener := range listeners {
+		addresses = append(addresses, listener.Addr().(*net.TCPAddr).IP.String())
+	}
+
+	return addresses
+}
+
+// Close stops listening and waits for the accept loops to exit.
+// Connections already accepted are closed by the port forwarder
+// when it stops.
+func (l *localListener) Close() {
+	l.mu.Lock()
+	l.closed = true
+	listeners := l.listeners
+	l.mu.Unlock()
+
+	closeListeners(listeners)
+	l.wg.Wait()
+}
+
+// acceptConnections is the accept loop of a local listener.
+func (l *localListener) acceptConnections(listener net.Listener) {
+	defer l.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Log(logger.LevelError, map[string]string{"address": listener.Addr().String()},
+					err, "accepting local connection")
+			}
+
+			return
+		}
+
+		if accepted, ok := l.probes.LoadAndDelete(conn.RemoteAddr().String()); ok {
+			close(accepted.(chan struct{}))
+			conn.Close()
+
+			continue
+		}
+
+		l.setConnOptions(conn)
+		l.serve(conn)
+	}
+}
+
+// serve proxies the connection to the target in a goroutine, as one of the
+// connections of the listener. The connection is closed right away if the
+// connection limit is reached.
+func (l *localListener) serve(conn net.Conn) {
+	if l.opts.activity != nil {
+		l.opts.activity.Store(time.Now().UnixNano())
+	}
+
+	if !l.opts.limit.acquire() {
+		conn.Close()
+
+		if l.opts.traffic != nil {
+			l.opts.traffic.rejectedConnections.Add(1)
+		}
+
+		return
+	}
+
+	l.conns.Add(1)
+
+	go func() {
+		defer l.conns.Done()
+		defer l.opts.limit.release()
+
+		l.proxyConnection(conn)
+	}()
+}
+
+// checkServing checks each of the local addresses still accepts connections.
+func (l *localListener) checkServing() error {
+	for _, listener := range l.current() {
+		if err := l.checkListenerServing(listener.Addr().(*net.TCPAddr)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkListenerServing connects to the listening address and waits for the
+// connection to be accepted. The connection is made from a local port picked
+// beforehand, so the accept loop can tell it apart from the proxied ones.
+func (l *localListener) checkListenerServing(addr *net.TCPAddr) error {
+	ip := addr.IP
+	if ip.IsUnspecified() {
+		ip = net.IPv6loopback
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+	}
+
+	source, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	if err != nil {
+		return err
+	}
+
+	local := source.Addr().(*net.TCPAddr)
+	source.Close()
+
+	accepted := make(chan struct{})
+	l.probes.Store(local.String(), accepted)
+
+	defer l.probes.Delete(local.String())
+
+	dialer := net.Dialer{LocalAddr: local, Timeout: probeTimeout}
+
+	conn, err := dialer.Dial("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port)))
+	if isAddrInUse(err) {
+		// The local port was taken in between, which says nothing about the listener.
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	select {
+	case <-accepted:
+		return nil
+	case <-time.After(probeTimeout):
+		return errors.New("connection to " + addr.String() + " not accepted")
+	}
+}
+
+// checkServing checks each of the listeners still accepts connections.
+func (ls localListeners) checkServing() error {
+	for _, l := range ls {
+		if err := l.checkServing(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setConnOptions sets the socket options on an accepted connection. Failing
+// to is logged, as the connection still works with the default ones.
+func (l *localListener) setConnOptions(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	opts := l.opts.socket
+	logParams := map[string]string{"remote": conn.RemoteAddr().String()}
+
+	if err := tcpConn.SetNoDelay(opts.NoDelay); err != nil {
+		logger.Log(logger.LevelWarn, logParams, err, "setting TCP_NODELAY on local connection")
+	}
+
+	if opts.ReadBufferBytes > 0 {
+		if err := tcpConn.SetReadBuffer(opts.ReadBufferBytes); err != nil {
+			logger.Log(logger.LevelWarn, logParams, err, "setting read buffer size on local connection")
+		}
+	}
+
+	if opts.WriteBufferBytes > 0 {
+		if err := tcpConn.SetWriteBuffer(opts.WriteBufferBytes); err != nil {
+			logger.Log(logger.LevelWarn, logParams, err, "setting write buffer size on local connection")
+		}
+	}
+}
+
+// proxyConnection copies data between the local connection and the port forwarder.
+func (l *localListener) proxyConnection(conn net.Conn) {
+	defer conn.Close()
+
+	target := l.getTarget()
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"target": target}, err, "connecting to port forwarder")
+
+		return
+	}
+
+	defer upstream.Close()
+
+	if l.opts.activity != nil || l.opts.traffic != nil {
+		local := &trackedConn{Conn: conn, activity: l.opts.activity}
+		remote := &trackedConn{Conn: upstream, activity: l.opts.activity}
+
+		if traffic := l.opts.traffic; traffic != nil {
+			local.bytes, remote.bytes = &traffic.bytesIn, &traffic.bytesOut
+
+			traffic.connectionOpened()
+			defer traffic.connectionClosed()
+		}
+
+		conn, upstream = local, remote
+	}
+
+	if l.opts.idleTimeout > 0 {
+		l.proxyIdleConnection(conn, upstream)
+
+		return
+	}
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+
+	// Once either side is done, the deferred closes unblock the other copy.
+	<-done
+}
+
+// proxyIdleConnection copies data between the local connection and the port
+// forwarder until either side is done, or there is no traffic in either
+// direction for the idle timeout.
+func (l *localListener) proxyIdleConnection(conn net.Conn, upstream net.Conn) {
+	lastActivity := new(atomic.Int64)
+	lastActivity.Store(time.Now().UnixNano())
+
+	idle := make(chan bool, 2)
+
+	go func() {
+		idle <- copyUntilIdle(upstream, conn, lastActivity, l.opts.idleTimeout)
+	}()
+
+	go func() {
+		idle <- copyUntilIdle(conn, upstream, lastActivity, l.opts.idleTimeout)
+	}()
+
+	if <-idle {
+		logger.Log(logger.LevelInfo, map[string]string{"remote": conn.RemoteAddr().String()}, nil,
+			"closing idle local connection")
+
+		if l.opts.onIdleReap != nil {
+			l.opts.onIdleReap()
+		}
+	}
+}
+
+// copyUntilIdle copies from src to dst, recording the traffic in lastActivity,
+// which is shared with the copy in the other direction. It tells whether it
+// stopped because there was no traffic for timeout.
+func copyUntilIdle(dst net.Conn, src net.Conn, lastActivity *atomic.Int64, timeout time.Duration) bool {
+	buf := make([]byte, 32*1024)
+
+	for {
+		if err := src.SetReadDeadline(time.Unix(0, lastActivity.Load()).Add(timeout)); err != nil {
+			return false
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return false
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+
+		// The other direction may have had traffic meanwhile.
+		if errors.Is(err, os.ErrDeadlineExceeded) &&
+			time.Since(time.Unix(0, lastActivity.Load())) < timeout {
+			continue
+		}
+
+		return errors.Is(err, os.ErrDeadlineExceeded)
+	}
+}
+
+// trackedConn records the time of the data read from the connection in
+// activity, and counts it in bytes, each if set.
+type trackedConn struct {
+	net.Conn
+	activity *atomic.Int64
+	bytes    *atomic.Int64
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if c.activity != nil {
+			c.activity.Store(time.Now().UnixNano())
+		}
+
+		if c.bytes != nil {
+			c.bytes.Add(int64(n))
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,53 @@
+//go:build !windows
+
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSupported tells whether SO_REUSEPORT can be set on this platform.
+const reusePortSupported = true
+
+// setListenerSockopts sets SO_REUSEADDR on the listening socket, so that a
+// port forward can be restarted on the same port while previous connections
+// are in TIME_WAIT, and SO_REUSEPORT when requested.
+func setListenerSockopts(conn syscall.RawConn, opts listenOptions) error {
+	var sockErr error
+
+	err := conn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if sockErr == nil && opts.reusePort {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+// isAddrInUse tells whether listening failed because the address is in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, unix.EADDRINUSE)
+}
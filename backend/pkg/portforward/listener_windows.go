@@ -0,0 +1,41 @@
+//go:build windows
+
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// reusePortSupported tells whether SO_REUSEPORT can be set on this platform.
+const reusePortSupported = false
+
+// setListenerSockopts does nothing on Windows. SO_REUSEADDR there lets other
+// sockets steal a port in use rather than allowing rebinding during
+// TIME_WAIT, and SO_REUSEPORT doesn't exist.
+func setListenerSockopts(conn syscall.RawConn, opts listenOptions) error {
+	return nil
+}
+
+// isAddrInUse tells whether listening failed because the address is in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE)
+}
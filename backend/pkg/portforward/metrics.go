@@ -0,0 +1,156 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// errorStageSetup is for port forwards failing to start.
+	errorStageSetup = "setup"
+	// errorStageStream is for connections of running port forwards failing to be forwarded.
+	errorStageStream = "stream"
+	// errorStageKeepAlive is for running port forwards failing to be kept alive.
+	errorStageKeepAlive = "keepalive"
+)
+
+// portForwardMetrics are the counters of the port forwards. They are recorded
+// with the context of the setup span of the port forward, so the exemplars
+// of the counters, when enabled, link to its trace. The lifecycle ones are
+// only labeled with the cluster, to keep their cardinality bounded.
+type portForwardMetrics struct {
+	starts metric.Int64Counter
+	errors metric.Int64Counter
+	// running is the number of running port forwards, by cluster.
+	running           metric.Int64UpDownCounter
+	readinessTimeouts metric.Int64Counter
+	rbacDenials       metric.Int64Counter
+	podMonitorStops   metric.Int64Counter
+}
+
+// newCounter returns the counter of the meter, or a counter recording nothing
+// if it can't be created.
+func newCounter(meter metric.Meter, name string, description string) metric.Int64Counter {
+	counter, err := meter.Int64Counter(name, metric.WithDescription(description))
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"metric": name}, err, "creating portforward counter")
+
+		return noop.Int64Counter{}
+	}
+
+	return counter
+}
+
+var (
+	metrics     portForwardMetrics
+	metricsOnce sync.Once
+)
+
+// getMetrics returns the port forward counters, created with the global meter
+// provider, which doesn't record anything unless metrics are enabled.
+func getMetrics() portForwardMetrics {
+	metricsOnce.Do(func() {
+		meter := otel.Meter("headlamp")
+
+		running, err := meter.Int64UpDownCounter("headlamp.portforward.running",
+			metric.WithDescription("Number of running port forwards, by cluster"))
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "creating portforward running gauge")
+
+			running = noop.Int64UpDownCounter{}
+		}
+
+		metrics = portForwardMetrics{
+			starts: newCounter(meter, "headlamp.portforward.starts", "Number of port forwards started"),
+			errors: newCounter(meter, "headlamp.portforward.errors",
+				"Number of port forward errors, by stage and error code"),
+			running: running,
+			readinessTimeouts: newCounter(meter, "headlamp.portforward.readiness_timeouts",
+				"Number of port forwards not ready within their readiness timeout, by cluster"),
+			rbacDenials: newCounter(meter, "headlamp.portforward.rbac_denials",
+				"Number of port forwards denied by the RBAC of the cluster, by cluster"),
+			podMonitorStops: newCounter(meter, "headlamp.portforward.pod_monitor_stops",
+				"Number of port forwards stopped once the pod monitor lost their pod, by cluster"),
+		}
+	})
+
+	return metrics
+}
+
+// metricAttributes returns the attributes identifying the port forward in its metrics.
+func metricAttributes(pf *portForward) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("portforward.id", pf.ID),
+		attribute.String("portforward.cluster", pf.Cluster),
+		attribute.String("portforward.namespace", pf.Namespace),
+	}
+}
+
+// clusterAttributes returns the attributes of the lifecycle metrics of the port forward.
+func clusterAttributes(pf *portForward) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("portforward.cluster", pf.Cluster))
+}
+
+// setupContext returns a context with the setup span of the port forward, to
+// record its metrics with.
+func (p *portForward) setupContext() context.Context {
+	return trace.ContextWithSpanContext(context.Background(), p.setupSpan)
+}
+
+// recordStart counts a started port forward.
+func recordStart(pf *portForward) {
+	getMetrics().starts.Add(pf.setupContext(), 1, metric.WithAttributes(metricAttributes(pf)...))
+}
+
+// recordError counts an error of the port forward at the stage.
+func recordError(pf *portForward, stage string, err error) {
+	attrs := append(metricAttributes(pf),
+		attribute.String("portforward.error.stage", stage),
+		attribute.String("portforward.error.code", string(errorCode(err))),
+	)
+
+	getMetrics().errors.Add(pf.setupContext(), 1, metric.WithAttributes(attrs...))
+}
+
+// recordRunning counts the port forward as running, or no longer running if delta is negative.
+func recordRunning(pf *portForward, delta int64) {
+	getMetrics().running.Add(pf.setupContext(), delta, clusterAttributes(pf))
+}
+
+// recordReadinessTimeout counts a port forward not ready within its readiness timeout.
+func recordReadinessTimeout(pf *portForward) {
+	getMetrics().readinessTimeouts.Add(pf.setupContext(), 1, clusterAttributes(pf))
+}
+
+// recordRBACDenial counts a port forward denied by the RBAC of its cluster.
+func recordRBACDenial(pf *portForward) {
+	getMetrics().rbacDenials.Add(pf.setupContext(), 1, clusterAttributes(pf))
+}
+
+// recordPodMonitorStop counts a port forward stopped once its pod was lost.
+func recordPodMonitorStop(pf *portForward) {
+	getMetrics().podMonitorStops.Add(pf.setupContext(), 1, clusterAttributes(pf))
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// The probes which can be run once a port forward is running. They only read
+// what the service tells about itself, and never send more than a fixed request.
+const (
+	// ProbeBanner reads what the service sends on connect, like MySQL or SSH do.
+	ProbeBanner = "banner"
+	// ProbePostgres sends a PostgreSQL SSLRequest, answered before authentication.
+	ProbePostgres = "postgres"
+	// ProbeRedis sends a Redis PING.
+	ProbeRedis = "redis"
+)
+
+const (
+	// probeTimeout is how long a probe can take, connecting included.
+	probeTimeout = 2 * time.Second
+	// probeMaxBytes is the most a probe reads from the service.
+	probeMaxBytes = 256
+)
+
+// probeRequests are the probes and what they send, if anything.
+var probeRequests = map[string][]byte{
+	ProbeBanner: nil,
+	// The length (8) and the SSLRequest code (80877103).
+	ProbePostgres: {0, 0, 0, 8, 4, 210, 22, 47},
+	ProbeRedis:    []byte("PING\r\n"),
+}
+
+// probeResult is the outcome of the probe of a port forward.
+type probeResult struct {
+	Probe string `json:"probe"`
+	// Detected is what was recognized from the response, e.g. "MySQL 8.0.36",
+	// or "no response".
+	Detected string `json:"detected"`
+	// Response is the start of the response, with the unprintable bytes replaced by dots.
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// isValidProbe tells whether the probe is one of the known probes.
+func isValidProbe(probe string) bool {
+	_, ok := probeRequests[probe]
+
+	return ok
+}
+
+// printable replaces the unprintable bytes of the response by dots.
+func printable(response []byte) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '.'
+		}
+
+		return r
+	}, string(response))
+}
+
+// detect tells what the response to the probe is from.
+func detect(probe string, response []byte) string {
+	if len(response) == 0 {
+		return "no response"
+	}
+
+	switch probe {
+	case ProbePostgres:
+		if response[0] == 'S' || response[0] == 'N' {
+			return "PostgreSQL"
+		}
+	case ProbeRedis:
+		if bytes.HasPrefix(response, []byte("+PONG")) || bytes.HasPrefix(response, []byte("-NOAUTH")) {
+			return "Redis"
+		}
+	case ProbeBanner:
+		// The MySQL handshake is a packet header then protocol version 10
+		// and the null terminated server version.
+		if len(response) > 5 && response[4] == 10 {
+			if end := bytes.IndexByte(response[5:], 0); end > 0 {
+				return "MySQL " + printable(response[5:5+end])
+			}
+		}
+
+		if bytes.HasPrefix(response, []byte("SSH-")) {
+			return strings.TrimSpace(printable(bytes.SplitN(response, []byte("\r\n"), 2)[0]))
+		}
+	}
+
+	return "unrecognized response"
+}
+
+// runProbe runs the probe against the address.
+func runProbe(probe string, address string) probeResult {
+	result := probeResult{Probe: probe, At: time.Now()}
+
+	conn, err := net.DialTimeout("tcp", address, probeTimeout)
+	if err != nil {
+		result.Detected = "no response"
+		result.Error = err.Error()
+
+		return result
+	}
+
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(probeTimeout)); err != nil {
+		result.Error = err.Error()
+
+		return result
+	}
+
+	if request := probeRequests[probe]; len(request) > 0 {
+		if _, err := conn.Write(request); err != nil {
+			result.Detected = "no response"
+			result.Error = err.Error()
+
+			return result
+		}
+	}
+
+	response := make([]byte, probeMaxBytes)
+
+	n, err := conn.Read(response)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		result.Error = err.Error()
+	}
+
+	result.Detected = detect(probe, response[:n])
+	result.Response = printable(response[:n])
+
+	return result
+}
+
+// probePortForward runs the probe of the port forward through its tunnel
+// and keeps the result in its shared state, the copies of the port forward
+// stored or read having it from then on.
+func probePortForward(pfDetails *portForward, probe string, t *tunnel) {
+	address, err := t.address()
+	if err != nil {
+		return
+	}
+
+	result := runProbe(probe, address)
+
+	logger.Log(logger.LevelInfo, pfDetails.logParams(map[string]string{"probe": probe}), nil,
+		"port forward probe: "+result.Detected)
+
+	pfDetails.probeResult.Store(&result)
+}
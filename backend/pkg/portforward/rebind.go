@@ -0,0 +1,212 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// rebindPortForwardRequest is the payload of the rebind port forward request handler.
+type rebindPortForwardRequest struct {
+	ID      string `json:"id"`
+	Cluster string `json:"cluster"`
+	// Port is the new local port.
+	Port string `json:"port"`
+	// TargetPort is the target port, or its name, of the port pair to rebind,
+	// the first one if empty.
+	TargetPort string `json:"targetPort,omitempty"`
+}
+
+func (r *rebindPortForwardRequest) Validate() error {
+	if r.ID == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, id is required")
+	}
+
+	if r.Cluster == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, cluster is required")
+	}
+
+	if r.Port == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, port is required")
+	}
+
+	port, err := normalizePort("port", r.Port, false)
+	if err != nil {
+		return err
+	}
+
+	r.Port = port
+
+	return nil
+}
+
+// rebindRequest asks the supervisor of a tunnel to move the local port of the
+// port pair of targetPort to port, the result being sent on result.
+type rebindRequest struct {
+	targetPort string
+	port       string
+	result     chan error
+}
+
+// rebindIndex returns the index of the port pair of the target port, the
+// first one if empty.
+func rebindIndex(pairs []PortPair, targetPort string) (int, error) {
+	if targetPort == "" {
+		return 0, nil
+	}
+
+	for i, pair := range pairs {
+		if pair.TargetPort == targetPort || pair.TargetPortName == targetPort {
+			return i, nil
+		}
+	}
+
+	return 0, newError(ErrCodeNotFound, nil, "portforward doesn't forward target port %s", targetPort)
+}
+
+// rebindLocalPort moves the local port of a port pair of the running port
+// forward, keeping its tunnel, and stores it with its new port. It's called
+// by the supervisor of the tunnel, which owns pfDetails and the listeners.
+func rebindLocalPort(cache cache.Cache[interface{}], pfDetails *portForward, listeners localListeners,
+	req rebindRequest,
+) error {
+	pairs := pfDetails.portPairs()
+
+	index, err := rebindIndex(pairs, req.targetPort)
+	if err != nil {
+		return err
+	}
+
+	for i, pair := range pairs {
+		if pair.Port != req.port {
+			continue
+		}
+
+		if i == index {
+			return newError(ErrCodeInvalidRequest, nil, "portforward %s already listens on local port %s",
+				pfDetails.ID, req.port)
+		}
+
+		return newError(ErrCodePortUnavailable, nil, "local port %s is already used by portforward %s",
+			req.port, pfDetails.ID)
+	}
+
+	l := listeners[index]
+	if err := l.rebind(l.Addresses(), req.port); err != nil {
+		if isAddrInUse(err) {
+			return newError(ErrCodePortUnavailable, nil, "local port %s is already in use", req.port)
+		}
+
+		return err
+	}
+
+	previous := pairs[index].Port
+	pairs[index].Port = req.port
+
+	pfDetails.setPortPairs(pairs)
+	pfDetails.Addresses = listeners[0].Addresses()
+
+	pfDetails.recordEvent(eventRebound, "local port "+previous+" moved to "+req.port)
+
+	logger.Log(logger.LevelInfo, pfDetails.logParams(map[string]string{"port": req.port, "previousPort": previous}),
+		nil, "port forward rebound")
+
+	return storePortForward(cache, *pfDetails)
+}
+
+// rebindPortForward moves a local port of a running port forward to another
+// one, without stopping its tunnel, and returns the port forward rebound.
+func rebindPortForward(ctx context.Context, cache cache.Cache[interface{}], cluster string,
+	p rebindPortForwardRequest,
+) (portForward, error) {
+	pf, err := getPortForwardByID(cache, cluster, p.ID)
+	if err != nil {
+		return portForward{}, err
+	}
+
+	if pf.WebSocket {
+		return portForward{}, newError(ErrCodeInvalidRequest, nil,
+			"portforward %s is bridged to WebSockets, it has no local port", p.ID)
+	}
+
+	// The tunnels of the port forwards from another backend aren't supervised here.
+	if pf.Status != RUNNING || pf.rebinds == nil {
+		return portForward{}, newError(ErrCodeStopped, nil, "portforward %s is not running", p.ID)
+	}
+
+	req := rebindRequest{targetPort: p.TargetPort, port: p.Port, result: make(chan error, 1)}
+
+	select {
+	case pf.rebinds <- req:
+	case <-pf.closeChan:
+		return portForward{}, newError(ErrCodeStopped, nil, "portforward %s is not running", p.ID)
+	case <-ctx.Done():
+		return portForward{}, ctx.Err()
+	}
+
+	select {
+	case err := <-req.result:
+		if err != nil {
+			return portForward{}, err
+		}
+	case <-ctx.Done():
+		return portForward{}, ctx.Err()
+	}
+
+	return getPortForwardByID(cache, cluster, p.ID)
+}
+
+// RebindPortForward handles the request moving a local port of a running port
+// forward, e.g. when the port it picked is wanted by something else. Only the
+// local listener is replaced, the tunnel to the pod and the connections
+// already accepted are kept. It responds with the port forward rebound.
+func RebindPortForward(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	var p rebindPortForwardRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding portforward rebind payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := p.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating portforward rebind payload")
+		writeError(w, err)
+
+		return
+	}
+
+	pf, err := rebindPortForward(r.Context(), cache, userClusterName(r, p.Cluster), p)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"id": p.ID, "port": p.Port}, err, "rebinding portforward")
+		writeError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(pf); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/kubeconfig"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+const (
+	// localPortDialTimeout is how long to wait when checking that a local port is bound.
+	localPortDialTimeout = time.Second
+	// staleMonitorChecks is the number of missed pod checks after which the
+	// pod monitor of a port forward is considered dead.
+	staleMonitorChecks = 3
+)
+
+// reconcileChange describes a port forward whose cached state was corrected.
+type reconcileChange struct {
+	ID             string `json:"id"`
+	PreviousStatus string `json:"previousStatus"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason"`
+}
+
+// reconcileReport is the result of reconciling the port forwards of a cluster.
+type reconcileReport struct {
+	Checked int               `json:"checked"`
+	Changes []reconcileChange `json:"changes"`
+}
+
+// checkLocalPort tells whether something accepts connections on the local
+// ports of the port forward.
+func checkLocalPort(pf portForward) error {
+	// The connections of a WebSocket port forward are bridged by the backend.
+	if pf.WebSocket {
+		return nil
+	}
+
+	address := "localhost"
+	if len(pf.Addresses) > 0 {
+		address = pf.Addresses[0]
+	}
+
+	for _, pair := range pf.portPairs() {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, pair.Port), localPortDialTimeout)
+		if err != nil {
+			return fmt.Errorf("local port %s is not accepting connections: %w", pair.Port, err)
+		}
+
+		if err := conn.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPodMonitor tells whether the pod monitor of the port forward checked
+// the pod recently enough to be considered alive.
+func checkPodMonitor(pf portForward) error {
+	// A disabled pod monitor doesn't check the pod.
+	if pf.MonitorDisabled {
+		return nil
+	}
+
+	if pf.lastPodCheck == nil {
+		return errors.New("pod monitor is not running")
+	}
+
+	lastCheck := time.Unix(0, pf.lastPodCheck.Load())
+	if time.Since(lastCheck) > staleMonitorChecks*PodAvailabilityCheckTimer*time.Second {
+		return fmt.Errorf("pod monitor has not checked the pod since %s", lastCheck.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// reconcilePortForwards checks every running port forward of the cluster
+// against its local port, its pod and its pod monitor. The ones found dead
+// are stopped and marked STOPPED with the reason.
+func reconcilePortForwards(cache cache.Cache[interface{}], cluster string,
+	checkPod func(namespace, pod string) error,
+) (reconcileReport, error) {
+	report := reconcileReport{Changes: []reconcileChange{}}
+
+	portForwards, err := getPortForwardList(cache, cluster)
+	if err != nil {
+		return report, err
+	}
+
+	for _, pf := range portForwards {
+		if pf.Status != RUNNING {
+			continue
+		}
+
+		report.Checked++
+
+		err := checkLocalPort(pf)
+		if err == nil {
+			err = checkPodMonitor(pf)
+		}
+
+		if err == nil {
+			if podErr := checkPod(pf.Namespace, pf.Pod); podErr != nil {
+				err = fmt.Errorf("pod %s/%s check failed: %w", pf.Namespace, pf.Pod, podErr)
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+
+		logger.Log(logger.LevelInfo, pf.logParams(map[string]string{"cluster": cluster}),
+			err, "reconciling portforward, marking it stopped")
+
+		pf.Status = STOPPED
+		pf.Error = err.Error()
+
+		portforwardstore(cache, pf)
+		safeCloseChan(pf.closeChan)
+
+		report.Changes = append(report.Changes, reconcileChange{
+			ID:             pf.ID,
+			PreviousStatus: RUNNING,
+			Status:         STOPPED,
+			Reason:         pf.Error,
+		})
+	}
+
+	return report, nil
+}
+
+// ReconcilePortForwards handles the reconcile port forwards request. It verifies
+// that the running port forwards of a cluster are actually alive, corrects the
+// cached state of the ones that aren't, and returns a report of the changes.
+func ReconcilePortForwards(kubeConfigStore kubeconfig.ContextStore, cache cache.Cache[interface{}],
+	w http.ResponseWriter, r *http.Request,
+) {
+	cluster := r.URL.Query().Get("cluster")
+	if cluster == "" {
+		logger.Log(logger.LevelError, nil, errors.New("cluster is required"), "reconciling portforwards")
+		http.Error(w, "cluster is required", http.StatusBadRequest)
+
+		return
+	}
+
+	clusterName := userClusterName(r, cluster)
+
+	kContext, err := kubeConfigStore.GetContext(clusterName)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"cluster": cluster}, err, "getting kubeconfig context")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	impersonate, err := impersonation(r)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"cluster": cluster}, err, "reconciling portforwards")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	clientset, _, err := getKubeClientAndConfig(kContext, bearerToken(r), impersonate)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"cluster": cluster}, err, "reconciling portforwards")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	report, err := reconcilePortForwards(cache, clusterName, func(namespace, pod string) error {
+		return checkIfPodIsRunning(r.Context(), clientset, namespace, pod)
+	})
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"cluster": cluster}, err, "reconciling portforwards")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+		http.Error(w, "failed to write json payload to response "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"encoding/json"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// MaxNameLength is the maximum length, in characters, of the name of a port forward.
+const MaxNameLength = 63
+
+// validateName checks the name of a port forward, which is only shown.
+func validateName(name string) error {
+	if n := utf8.RuneCountInString(name); n > MaxNameLength {
+		return newError(ErrCodeInvalidRequest, nil, "name must be at most %d characters, got %d", MaxNameLength, n)
+	}
+
+	return nil
+}
+
+// renamePortForwardRequest is the payload of the rename port forward request handler.
+type renamePortForwardRequest struct {
+	ID      string `json:"id"`
+	Cluster string `json:"cluster"`
+	// Name is the new name, the name is removed if empty.
+	Name string `json:"name"`
+}
+
+func (r *renamePortForwardRequest) Validate() error {
+	if r.ID == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, id is required")
+	}
+
+	if r.Cluster == "" {
+		return newError(ErrCodeInvalidRequest, nil, "invalid request, cluster is required")
+	}
+
+	return validateName(r.Name)
+}
+
+// renamePortForward sets the name of a port forward, whatever its status,
+// and returns the port forward renamed.
+func renamePortForward(cache cache.Cache[interface{}], cluster string, p renamePortForwardRequest,
+) (portForward, error) {
+	pf, err := getPortForwardByID(cache, cluster, p.ID)
+	if err != nil {
+		return portForward{}, err
+	}
+
+	// The copies kept by the goroutines of a running port forward are stored
+	// with the name they share.
+	if pf.name != nil {
+		pf.name.Store(&p.Name)
+	}
+
+	pf.Name = p.Name
+
+	if err := storePortForward(cache, pf); err != nil {
+		return portForward{}, err
+	}
+
+	return pf, nil
+}
+
+// RenamePortForward handles the request setting the name of a port forward,
+// shown to tell the port forwards apart. It responds with the port forward
+// renamed.
+func RenamePortForward(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	var p renamePortForwardRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		logger.Log(logger.LevelError, nil, err, "decoding portforward rename payload")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := p.Validate(); err != nil {
+		logger.Log(logger.LevelError, nil, err, "validating portforward rename payload")
+		writeError(w, err)
+
+		return
+	}
+
+	pf, err := renamePortForward(cache, userClusterName(r, p.Cluster), p)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"id": p.ID}, err, "renaming portforward")
+		writeError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(pf); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+	}
+}
@@ -0,0 +1,479 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PodSelectionReadyFirst picks a ready pod, the first by name, if any,
+	// and otherwise the first running pod by name.
+	PodSelectionReadyFirst = "ready-first"
+	// PodSelectionNewest picks the most recently created pod.
+	PodSelectionNewest = "newest"
+	// PodSelectionOldest picks the least recently created pod.
+	PodSelectionOldest = "oldest"
+	// PodSelectionRandom picks a pod at random, e.g. to spread port forwards.
+	PodSelectionRandom = "random"
+	// PodSelectionRoundRobin picks the pods in turn, by name, each time the
+	// selection is resolved, e.g. to spread port forwards evenly.
+	PodSelectionRoundRobin = "round-robin"
+)
+
+// isValidPodSelectionStrategy tells whether the strategy is one of the PodSelection ones.
+func isValidPodSelectionStrategy(strategy string) bool {
+	switch strategy {
+	case PodSelectionReadyFirst, PodSelectionNewest, PodSelectionOldest, PodSelectionRandom, PodSelectionRoundRobin:
+		return true
+	}
+
+	return false
+}
+
+// podSelection selects the pods a port forward can target, so it can be
+// retargeted to another one when its pod goes away.
+type podSelection struct {
+	// labels selects the pods with these labels, e.g. the selector of a service.
+	labels labels.Set
+	// podTemplateHash selects the pods of a single ReplicaSet revision.
+	podTemplateHash string
+	// annotationKey selects the pods with this annotation, with the value
+	// annotationValue if it's not empty.
+	annotationKey   string
+	annotationValue string
+	// cronJob selects the pods of the latest Job of this CronJob.
+	cronJob string
+	// selector selects the ready pods matching this label selector, e.g. "app=web".
+	selector string
+	// strategy is how a pod is picked among the selected ones, one of the
+	// PodSelection strategies, PodSelectionReadyFirst if empty.
+	strategy string
+}
+
+// isEmpty tells whether the selection doesn't select any pods, in which case
+// the port forward only targets the pod it was started with.
+func (s podSelection) isEmpty() bool {
+	return len(s.labels) == 0 && s.podTemplateHash == "" && s.annotationKey == "" && s.cronJob == "" &&
+		s.selector == ""
+}
+
+// labelSelector returns the label selector of the selected pods.
+func (s podSelection) labelSelector() labels.Selector {
+	set := labels.Set{}
+
+	for key, value := range s.labels {
+		set[key] = value
+	}
+
+	if s.podTemplateHash != "" {
+		set[appsv1.DefaultDeploymentUniqueLabelKey] = s.podTemplateHash
+	}
+
+	selector := labels.SelectorFromSet(set)
+
+	// The label selector of the request was validated, so it parses.
+	if parsed, err := labels.Parse(s.selector); s.selector != "" && err == nil {
+		requirements, _ := parsed.Requirements()
+		selector = selector.Add(requirements...)
+	}
+
+	return selector
+}
+
+// matchesAnnotation tells whether the pod has the annotation of the selection, if any.
+func (s podSelection) matchesAnnotation(pod *corev1.Pod) bool {
+	if s.annotationKey == "" {
+		return true
+	}
+
+	value, ok := pod.Annotations[s.annotationKey]
+
+	return ok && (s.annotationValue == "" || value == s.annotationValue)
+}
+
+// matches tells whether the pod is selected.
+func (s podSelection) matches(pod *corev1.Pod) bool {
+	return s.labelSelector().Matches(labels.Set(pod.Labels)) && s.matchesAnnotation(pod)
+}
+
+func (s podSelection) String() string {
+	parts := []string{}
+
+	if s.cronJob != "" {
+		parts = append(parts, "cronjob "+s.cronJob)
+	}
+
+	if selector := s.labelSelector(); !selector.Empty() {
+		parts = append(parts, selector.String())
+	}
+
+	if s.annotationKey != "" {
+		annotation := s.annotationKey
+		if s.annotationValue != "" {
+			annotation += "=" + s.annotationValue
+		}
+
+		parts = append(parts, "annotation "+annotation)
+	}
+
+	return strings.Join(parts, " and ")
+}
+
+// isPodReady tells whether the pod has the Ready condition.
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// latestJob returns the most recently created Job of the CronJob.
+func latestJob(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	cronJob string,
+) (*batchv1.Job, error) {
+	cj, err := clientset.BatchV1().CronJobs(namespace).Get(ctx, cronJob, v1.GetOptions{})
+	if err != nil {
+		code := ErrCodeInternal
+		if apierrors.IsNotFound(err) {
+			code = ErrCodeNotFound
+		}
+
+		return nil, newError(code, err, "getting cronjob %s/%s", namespace, cronJob)
+	}
+
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, newError(ErrCodeInternal, err, "listing jobs in namespace %s", namespace)
+	}
+
+	var latest *batchv1.Job
+
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !v1.IsControlledBy(job, cj) {
+			continue
+		}
+
+		if latest == nil || latest.CreationTimestamp.Before(&job.CreationTimestamp) ||
+			(latest.CreationTimestamp.Equal(&job.CreationTimestamp) && latest.Name < job.Name) {
+			latest = job
+		}
+	}
+
+	if latest == nil {
+		return nil, newError(ErrCodeNotFound, nil, "cronjob %s/%s has no jobs", namespace, cronJob)
+	}
+
+	return latest, nil
+}
+
+// podSelector returns the label selector to list the pods of the selection
+// with, which for a CronJob selects the pods of its latest Job.
+func podSelector(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	sel podSelection,
+) (labels.Selector, error) {
+	selector := sel.labelSelector()
+	if sel.cronJob == "" {
+		return selector, nil
+	}
+
+	job, err := latestJob(ctx, clientset, namespace, sel.cronJob)
+	if err != nil {
+		return nil, err
+	}
+
+	jobSelector, err := v1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return nil, newError(ErrCodeInternal, err, "invalid selector of job %s/%s", namespace, job.Name)
+	}
+
+	requirements, _ := jobSelector.Requirements()
+
+	return selector.Add(requirements...), nil
+}
+
+// podJob returns the name of the Job controlling the pod, if any.
+func podJob(pod *corev1.Pod) string {
+	if owner := v1.GetControllerOf(pod); owner != nil && owner.Kind == "Job" {
+		return owner.Name
+	}
+
+	return ""
+}
+
+// resolvePod picks a running pod of the selection in the namespace with the
+// strategy of the selection.
+func resolvePod(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	sel podSelection,
+) (*corev1.Pod, error) {
+	selector, err := podSelector(ctx, clientset, namespace, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, newError(ErrCodeInternal, err, "listing pods with %s in namespace %s", sel, namespace)
+	}
+
+	candidates := []*corev1.Pod{}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		// Annotations can't be selected on by the apiserver.
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil || !sel.matchesAnnotation(pod) {
+			continue
+		}
+
+		// The pods selected by a label selector are the ready ones only.
+		if sel.selector != "" && !isPodReady(pod) {
+			continue
+		}
+
+		candidates = append(candidates, pod)
+	}
+
+	if len(candidates) == 0 {
+		if sel.selector != "" {
+			return nil, newError(ErrCodeNotFound, nil, "no ready pod with %s in namespace %s", sel, namespace)
+		}
+
+		return nil, newError(ErrCodeNotFound, nil, "no running pod with %s in namespace %s", sel, namespace)
+	}
+
+	return pickPod(candidates, sel.strategy, namespace+"/"+sel.String()), nil
+}
+
+// reconnectPollInterval is how often the pods are listed while waiting for a
+// ready pod to reconnect to.
+var reconnectPollInterval = time.Second
+
+// resolveReadyPod waits for resolvePod to pick a ready pod of the selection,
+// until ctx is done.
+func resolveReadyPod(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	sel podSelection,
+) (*corev1.Pod, error) {
+	for {
+		pod, err := resolvePod(ctx, clientset, namespace, sel)
+		if err == nil {
+			if isPodReady(pod) {
+				return pod, nil
+			}
+
+			err = newError(ErrCodeNotFound, nil, "no ready pod with %s in namespace %s", sel, namespace)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(reconnectPollInterval):
+		}
+	}
+}
+
+// workloadKinds are the kinds of the workloads whose pods can be port forwarded to.
+var workloadKinds = []string{"Deployment", "ReplicaSet", "StatefulSet", "DaemonSet"}
+
+// podOwnerSelector returns the workload controlling the pod, as "Kind/name",
+// and the selector of its pods. A ReplicaSet is resolved to its Deployment,
+// if any, so the pods of the next revisions are selected too.
+func podOwnerSelector(ctx context.Context, clientset kubernetes.Interface,
+	pod *corev1.Pod,
+) (string, labels.Set, error) {
+	owner := v1.GetControllerOf(pod)
+	if owner == nil {
+		return "", nil, newError(ErrCodeInvalidRequest, nil, "pod %s/%s has no controller to reconnect through",
+			pod.Namespace, pod.Name)
+	}
+
+	namespace := pod.Namespace
+	kind, name := owner.Kind, owner.Name
+
+	if kind == "Deployment" || !slices.Contains(workloadKinds, kind) {
+		return "", nil, newError(ErrCodeInvalidRequest, nil, "pod %s/%s is controlled by %s %s, which can't be "+
+			"reconnected through", namespace, pod.Name, kind, name)
+	}
+
+	if kind == "ReplicaSet" {
+		rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return "", nil, workloadError(err, kind, namespace, name)
+		}
+
+		if deployment := v1.GetControllerOf(rs); deployment != nil && deployment.Kind == "Deployment" {
+			kind, name = deployment.Kind, deployment.Name
+		}
+	}
+
+	return workloadSelector(ctx, clientset, namespace, kind, name)
+}
+
+// workloadSelector returns the workload of the kind, one of workloadKinds, as
+// "Kind/name", and the selector of its pods.
+func workloadSelector(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	kind string, name string,
+) (string, labels.Set, error) {
+	var (
+		selector *v1.LabelSelector
+		err      error
+	)
+
+	switch kind {
+	case "Deployment":
+		var d *appsv1.Deployment
+
+		d, err = clientset.AppsV1().Deployments(namespace).Get(ctx, name, v1.GetOptions{})
+		if err == nil {
+			selector = d.Spec.Selector
+		}
+	case "ReplicaSet":
+		var rs *appsv1.ReplicaSet
+
+		rs, err = clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err == nil {
+			selector = rs.Spec.Selector
+		}
+	case "StatefulSet":
+		var ss *appsv1.StatefulSet
+
+		ss, err = clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err == nil {
+			selector = ss.Spec.Selector
+		}
+	case "DaemonSet":
+		var ds *appsv1.DaemonSet
+
+		ds, err = clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err == nil {
+			selector = ds.Spec.Selector
+		}
+	default:
+		return "", nil, newError(ErrCodeInvalidRequest, nil, "unsupported workload kind %q, must be one of %s",
+			kind, strings.Join(workloadKinds, ", "))
+	}
+
+	if err != nil {
+		return "", nil, workloadError(err, kind, namespace, name)
+	}
+
+	// A pod selection only has labels, so the set based requirements are left out.
+	if selector == nil || len(selector.MatchLabels) == 0 {
+		return "", nil, newError(ErrCodeInvalidRequest, nil, "%s %s/%s has no label selector to reconnect through",
+			kind, namespace, name)
+	}
+
+	return kind + "/" + name, labels.Set(selector.MatchLabels), nil
+}
+
+// workloadError returns the error of getting the workload.
+func workloadError(err error, kind, namespace, name string) error {
+	code := ErrCodeInternal
+	if apierrors.IsNotFound(err) {
+		code = ErrCodeNotFound
+	}
+
+	return newError(code, err, "getting %s %s/%s", kind, namespace, name)
+}
+
+// roundRobinTurns are the number of pods picked so far by the round-robin
+// strategy, by selection.
+var roundRobinTurns sync.Map
+
+// pickPod picks one of the candidate pods with the strategy. Ties are broken
+// by name, so that the pick is deterministic unless random or round-robin,
+// which picks the next pod of the selection identified by key.
+func pickPod(candidates []*corev1.Pod, strategy string, key string) *corev1.Pod {
+	switch strategy {
+	case PodSelectionRandom:
+		return candidates[rand.IntN(len(candidates))]
+	case PodSelectionRoundRobin:
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+
+		turns, _ := roundRobinTurns.LoadOrStore(key, new(atomic.Uint64))
+		turn := turns.(*atomic.Uint64).Add(1) - 1
+
+		return candidates[turn%uint64(len(candidates))]
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		switch strategy {
+		case PodSelectionNewest:
+			if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+				return b.CreationTimestamp.Before(&a.CreationTimestamp)
+			}
+		case PodSelectionOldest:
+			if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+				return a.CreationTimestamp.Before(&b.CreationTimestamp)
+			}
+		default:
+			if isPodReady(a) != isPodReady(b) {
+				return isPodReady(a)
+			}
+		}
+
+		return a.Name < b.Name
+	})
+
+	return candidates[0]
+}
+
+// selectPod returns the pod the port forward targets: the named pod, which
+// must be part of the selection, or one resolved from the selection.
+func selectPod(ctx context.Context, clientset kubernetes.Interface, namespace string, name string,
+	sel podSelection,
+) (*corev1.Pod, error) {
+	if name == "" {
+		return resolvePod(ctx, clientset, namespace, sel)
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		code := ErrCodeInternal
+		if apierrors.IsNotFound(err) {
+			code = ErrCodeNotFound
+		}
+
+		return nil, newError(code, err, "getting pod %s/%s", namespace, name)
+	}
+
+	if !sel.matches(pod) {
+		return nil, newError(ErrCodeInvalidRequest, nil, "pod %s/%s doesn't match %s", namespace, name, sel)
+	}
+
+	return pod, nil
+}
@@ -0,0 +1,406 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// serviceResolution tells how a port of a service was resolved to a
+// container port of one of its pods, step by step.
+type serviceResolution struct {
+	Service string `json:"service"`
+	// ServicePort is the port of the service, by name or number as requested,
+	// empty when port forwarding to the service with a target port.
+	ServicePort string `json:"servicePort,omitempty"`
+	// ServiceTargetPort is the targetPort of the service port, a name or number.
+	ServiceTargetPort string `json:"serviceTargetPort"`
+	Pod               string `json:"pod"`
+	// ContainerPort is the container port number of the pod the targetPort resolved to.
+	ContainerPort string `json:"containerPort"`
+}
+
+// findServicePort returns the port of the service with the name or number.
+func findServicePort(svc *corev1.Service, port string) (*corev1.ServicePort, error) {
+	for i := range svc.Spec.Ports {
+		sp := &svc.Spec.Ports[i]
+		if sp.Name == port || strconv.Itoa(int(sp.Port)) == port {
+			return sp, nil
+		}
+	}
+
+	available := []string{}
+
+	for _, sp := range svc.Spec.Ports {
+		if sp.Name != "" {
+			available = append(available, sp.Name+"/"+strconv.Itoa(int(sp.Port)))
+		} else {
+			available = append(available, strconv.Itoa(int(sp.Port)))
+		}
+	}
+
+	return nil, newError(ErrCodeNotFound, nil, "service %s/%s has no port %q, available ports: %s",
+		svc.Namespace, svc.Name, port, strings.Join(available, ", "))
+}
+
+// namedContainerPorts returns the names of the container ports of the pod, of
+// the named container if set, sorted.
+func namedContainerPorts(pod *corev1.Pod, containerName string) []string {
+	names := []string{}
+
+	for _, container := range pod.Spec.Containers {
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+
+		for _, port := range container.Ports {
+			if port.Name != "" {
+				names = append(names, port.Name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// resolveTargetPort returns the number of the target port of the pod, resolving
+// it if it's the name of a container port, of the named container if set.
+func resolveTargetPort(pod *corev1.Pod, container string, targetPort string) (string, error) {
+	port, err := resolveContainerPort(pod, container, intstr.Parse(targetPort))
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(int(port)), nil
+}
+
+// getPod returns the named pod.
+func getPod(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	podName string,
+) (*corev1.Pod, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+	if err != nil {
+		code := ErrCodeInternal
+		if apierrors.IsNotFound(err) {
+			code = ErrCodeNotFound
+		}
+
+		return nil, newError(code, err, "getting pod %s/%s", namespace, podName)
+	}
+
+	return pod, nil
+}
+
+// declaredContainerPorts returns the container ports the pod declares, of the
+// named container if set, as container/number sorted by container then number.
+func declaredContainerPorts(pod *corev1.Pod, containerName string) []string {
+	ports := []string{}
+
+	for _, container := range pod.Spec.Containers {
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+
+		numbers := []int{}
+		for _, port := range container.Ports {
+			numbers = append(numbers, int(port.ContainerPort))
+		}
+
+		sort.Ints(numbers)
+
+		for _, number := range numbers {
+			ports = append(ports, container.Name+"/"+strconv.Itoa(number))
+		}
+	}
+
+	return ports
+}
+
+// verifyTargetPorts checks the resolved target ports of the pairs are container
+// ports the pod declares, of the named container if set, rather than letting
+// each local connection be reset when nothing listens on them.
+func verifyTargetPorts(pod *corev1.Pod, containerName string, pairs []PortPair) error {
+	for _, pair := range pairs {
+		declared := slices.ContainsFunc(pod.Spec.Containers, func(c corev1.Container) bool {
+			return (containerName == "" || c.Name == containerName) &&
+				slices.ContainsFunc(c.Ports, func(port corev1.ContainerPort) bool {
+					return strconv.Itoa(int(port.ContainerPort)) == pair.TargetPort
+				})
+		})
+		if !declared {
+			return newError(ErrCodeNotFound, nil, "pod %s/%s doesn't declare container port %s, declared ports: [%s]",
+				pod.Namespace, pod.Name, pair.TargetPort, strings.Join(declaredContainerPorts(pod, containerName), ", "))
+		}
+	}
+
+	return nil
+}
+
+// resolveContainerPort returns the number of the container port of the pod
+// the target port refers to by number or name. A name is looked up in the
+// named container if set, which the pod must have. Otherwise, it must not name
+// different port numbers in different containers, as the port to forward to
+// can't be told then.
+func resolveContainerPort(pod *corev1.Pod, containerName string, targetPort intstr.IntOrString) (int32, error) {
+	if containerName != "" && !slices.ContainsFunc(pod.Spec.Containers, func(c corev1.Container) bool {
+		return c.Name == containerName
+	}) {
+		return 0, newError(ErrCodeNotFound, nil, "pod %s/%s has no container %q, containers: [%s]",
+			pod.Namespace, pod.Name, containerName, strings.Join(containerNames(pod), ", "))
+	}
+
+	if targetPort.Type == intstr.Int {
+		return targetPort.IntVal, nil
+	}
+
+	var number int32
+
+	numbers := map[int32]bool{}
+	candidates := []string{}
+
+	for _, container := range pod.Spec.Containers {
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+
+		for _, port := range container.Ports {
+			if port.Name == targetPort.StrVal {
+				number = port.ContainerPort
+				numbers[number] = true
+				candidates = append(candidates, container.Name+"/"+strconv.Itoa(int(number)))
+			}
+		}
+	}
+
+	switch {
+	case len(numbers) == 1:
+		return number, nil
+	case len(numbers) > 1:
+		return 0, newError(ErrCodeInvalidRequest, nil,
+			"container port name %q of pod %s/%s is ambiguous, set the container, candidates: [%s]",
+			targetPort.StrVal, pod.Namespace, pod.Name, strings.Join(candidates, ", "))
+	case containerName != "":
+		return 0, newError(ErrCodeNotFound, nil,
+			"container %q of pod %s/%s has no port named %q, named ports: [%s]", containerName, pod.Namespace,
+			pod.Name, targetPort.StrVal, strings.Join(namedContainerPorts(pod, containerName), ", "))
+	}
+
+	return 0, newError(ErrCodeNotFound, nil, "pod %s/%s has no container port named %q, named ports: [%s]",
+		pod.Namespace, pod.Name, targetPort.StrVal, strings.Join(namedContainerPorts(pod, ""), ", "))
+}
+
+// containerNames returns the names of the containers of the pod.
+func containerNames(pod *corev1.Pod) []string {
+	names := make([]string, 0, len(pod.Spec.Containers))
+
+	for _, container := range pod.Spec.Containers {
+		names = append(names, container.Name)
+	}
+
+	return names
+}
+
+// getService returns the service, which must have a selector to find its pods with.
+func getService(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	service string,
+) (*corev1.Service, error) {
+	svc, err := clientset.CoreV1().Services(namespace).Get(ctx, service, v1.GetOptions{})
+	if err != nil {
+		code := ErrCodeInternal
+		if apierrors.IsNotFound(err) {
+			code = ErrCodeNotFound
+		}
+
+		return nil, newError(code, err, "getting service %s/%s", namespace, service)
+	}
+
+	if len(svc.Spec.Selector) == 0 {
+		return nil, newError(ErrCodeInvalidRequest, nil,
+			"service %s/%s has no selector to find its pods with", namespace, service)
+	}
+
+	return svc, nil
+}
+
+// readyEndpointPods returns the names of the pods of the ready endpoints of the service, sorted.
+func readyEndpointPods(ctx context.Context, clientset kubernetes.Interface, svc *corev1.Service) ([]string, error) {
+	endpointSlices, err := clientset.DiscoveryV1().EndpointSlices(svc.Namespace).List(ctx, v1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + svc.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for _, endpointSlice := range endpointSlices.Items {
+		for _, endpoint := range endpointSlice.Endpoints {
+			ready := endpoint.Conditions.Ready != nil && *endpoint.Conditions.Ready
+			if ready && endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" &&
+				!slices.Contains(names, endpoint.TargetRef.Name) {
+				names = append(names, endpoint.TargetRef.Name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// pickServicePod returns the named pod, which must be selected by the service,
+// or picks one. With the ready-first strategy, it's the first running pod by
+// name of the ready endpoints of the service. Otherwise, or if there are none,
+// it's picked with the strategy among the running pods the service selects.
+func pickServicePod(ctx context.Context, clientset kubernetes.Interface, svc *corev1.Service,
+	podName string, strategy string,
+) (*corev1.Pod, podSelection, error) {
+	sel := podSelection{labels: svc.Spec.Selector, strategy: strategy}
+
+	if podName == "" && (strategy == "" || strategy == PodSelectionReadyFirst) {
+		names, err := readyEndpointPods(ctx, clientset, svc)
+		if err != nil {
+			logger.Log(logger.LevelWarn, map[string]string{"service": svc.Name, "namespace": svc.Namespace},
+				err, "listing service endpoints, falling back to its selector")
+		}
+
+		for _, name := range names {
+			pod, err := clientset.CoreV1().Pods(svc.Namespace).Get(ctx, name, v1.GetOptions{})
+			if err == nil && pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+				return pod, sel, nil
+			}
+		}
+	}
+
+	pod, err := selectPod(ctx, clientset, svc.Namespace, podName, sel)
+	if err != nil {
+		return nil, podSelection{}, err
+	}
+
+	return pod, sel, nil
+}
+
+// resolveService resolves the port of the service to a container port of the
+// named pod, or of a pod picked with the strategy among the ones the service
+// selects. A named target port is looked up in the container if set.
+func resolveService(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	service string, servicePort string, podName string, container string, strategy string,
+) (*corev1.Pod, *serviceResolution, podSelection, error) {
+	svc, err := getService(ctx, clientset, namespace, service)
+	if err != nil {
+		return nil, nil, podSelection{}, err
+	}
+
+	return resolveServicePort(ctx, clientset, svc, servicePort, podName, container, strategy)
+}
+
+// resolveServicePort resolves the port of the service, as resolveService does.
+func resolveServicePort(ctx context.Context, clientset kubernetes.Interface, svc *corev1.Service,
+	servicePort string, podName string, container string, strategy string,
+) (*corev1.Pod, *serviceResolution, podSelection, error) {
+	sp, err := findServicePort(svc, servicePort)
+	if err != nil {
+		return nil, nil, podSelection{}, err
+	}
+
+	pod, sel, err := pickServicePod(ctx, clientset, svc, podName, strategy)
+	if err != nil {
+		return nil, nil, podSelection{}, err
+	}
+
+	// An unset targetPort defaults to the port.
+	targetPort := sp.TargetPort
+	if targetPort.Type == intstr.Int && targetPort.IntVal == 0 {
+		targetPort = intstr.FromInt32(sp.Port)
+	}
+
+	containerPort, err := resolveContainerPort(pod, container, targetPort)
+	if err != nil {
+		return nil, nil, podSelection{}, err
+	}
+
+	return pod, &serviceResolution{
+		Service:           svc.Name,
+		ServicePort:       servicePort,
+		ServiceTargetPort: targetPort.String(),
+		Pod:               pod.Name,
+		ContainerPort:     strconv.Itoa(int(containerPort)),
+	}, sel, nil
+}
+
+// resolveServiceRequest resolves the pod and port of a port forward to a
+// service, by its service port if set and otherwise by its target port.
+func resolveServiceRequest(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	p portForwardRequest, strategy string,
+) (*corev1.Pod, *serviceResolution, podSelection, error) {
+	if p.ServicePort != "" {
+		return resolveService(ctx, clientset, namespace, p.Service, p.ServicePort, p.Pod, p.Container, strategy)
+	}
+
+	return resolveServiceTarget(ctx, clientset, namespace, p.Service, p.TargetPort, p.Container, strategy)
+}
+
+// resolveServiceTarget resolves a port forward to the service without a
+// service port, to its target port on a pod picked with the strategy. A named
+// target port is resolved as the port of the service with that name if there
+// is one, and otherwise as a container port of the pod.
+func resolveServiceTarget(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	service string, targetPort string, container string, strategy string,
+) (*corev1.Pod, *serviceResolution, podSelection, error) {
+	svc, err := getService(ctx, clientset, namespace, service)
+	if err != nil {
+		return nil, nil, podSelection{}, err
+	}
+
+	port := intstr.Parse(targetPort)
+	if port.Type == intstr.String {
+		if _, err := findServicePort(svc, targetPort); err == nil {
+			return resolveServicePort(ctx, clientset, svc, targetPort, "", container, strategy)
+		}
+	}
+
+	pod, sel, err := pickServicePod(ctx, clientset, svc, "", strategy)
+	if err != nil {
+		return nil, nil, podSelection{}, err
+	}
+
+	containerPort, err := resolveContainerPort(pod, container, port)
+	if err != nil {
+		return nil, nil, podSelection{}, err
+	}
+
+	return pod, &serviceResolution{
+		Service:           svc.Name,
+		ServiceTargetPort: targetPort,
+		Pod:               pod.Name,
+		ContainerPort:     strconv.Itoa(int(containerPort)),
+	}, sel, nil
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// stateStoreProbeKey is the key read to check the cache backend is available.
+// It is never written, so reading it fails with cache.ErrNotFound when all is well.
+const stateStoreProbeKey = "PORT_FORWARD_STATE_STORE_PROBE"
+
+// State store statuses.
+const (
+	stateStoreAvailable   = "available"
+	stateStoreDegraded    = "degraded"
+	stateStoreUnavailable = "unavailable"
+)
+
+// stateStore wraps the cache backend holding the port forwards. It tracks
+// whether the backend is available and, with the StoreUnavailableFallback
+// policy, mirrors the port forwards in memory to keep serving them while
+// the backend is unavailable.
+type stateStore struct {
+	backend cache.Cache[interface{}]
+	mirror  cache.Cache[interface{}]
+
+	mu          sync.Mutex
+	available   bool
+	lastError   string
+	lastErrorAt *time.Time
+}
+
+var (
+	stateStoresLock sync.Mutex
+	// stateStores are the state stores of the cache backends, until they are
+	// released by releaseStateStore.
+	stateStores = map[cache.Cache[interface{}]]*stateStore{}
+)
+
+// getStateStore returns the state store of the cache backend.
+func getStateStore(backend cache.Cache[interface{}]) *stateStore {
+	stateStoresLock.Lock()
+	defer stateStoresLock.Unlock()
+
+	s, ok := stateStores[backend]
+	if !ok {
+		s = &stateStore{backend: backend, mirror: cache.New[interface{}](), available: true}
+		stateStores[backend] = s
+	}
+
+	return s
+}
+
+// releaseStateStore forgets the state store of the cache backend once it's
+// discarded, so the backend isn't kept by stateStores.
+func releaseStateStore(backend cache.Cache[interface{}]) {
+	stateStoresLock.Lock()
+	defer stateStoresLock.Unlock()
+
+	delete(stateStores, backend)
+}
+
+// errStoreUnavailable wraps a failure of the cache backend.
+func errStoreUnavailable(err error) error {
+	return newError(ErrCodeStoreUnavailable, err, "port forward state store unavailable")
+}
+
+// fallback tells whether the mirror is used while the backend is unavailable.
+func (s *stateStore) fallback() bool {
+	return StoreUnavailablePolicy == StoreUnavailableFallback
+}
+
+// failed records the outcome of a backend operation and tells whether the
+// backend failed. A missing key isn't a failure.
+func (s *stateStore) failed(ctx context.Context, err error) bool {
+	failed, _ := s.check(ctx, err)
+
+	return failed
+}
+
+// check records the outcome of a backend operation. It tells whether the
+// backend failed, and whether it recovered and the mirror was written back to it.
+func (s *stateStore) check(ctx context.Context, err error) (failed bool, resynced bool) {
+	if err != nil && !errors.Is(err, cache.ErrNotFound) {
+		now := time.Now()
+
+		s.mu.Lock()
+		if s.available {
+			logger.Log(logger.LevelError, map[string]string{"policy": StoreUnavailablePolicy}, err,
+				"port forward state store unavailable")
+		}
+
+		s.available = false
+		s.lastError = err.Error()
+		s.lastErrorAt = &now
+		s.mu.Unlock()
+
+		return true, false
+	}
+
+	s.mu.Lock()
+	recovered := !s.available
+	s.available = true
+	s.mu.Unlock()
+
+	if !recovered {
+		return false, false
+	}
+
+	logger.Log(logger.LevelInfo, nil, nil, "port forward state store available again")
+
+	if !s.fallback() {
+		return false, false
+	}
+
+	s.resync(ctx)
+
+	return false, true
+}
+
+// resync writes the mirrored port forwards back to the backend once it's
+// available again, as the ones changed meanwhile were only mirrored.
+// Their TTLs are not carried over.
+func (s *stateStore) resync(ctx context.Context) {
+	entries, err := s.mirror.GetAll(ctx, nil)
+	if err != nil {
+		return
+	}
+
+	for key, value := range entries {
+		if err := s.backend.Set(ctx, key, value); err != nil {
+			logger.Log(logger.LevelError, map[string]string{"key": key}, err, "resyncing port forward state store")
+
+			return
+		}
+	}
+}
+
+func setWithTTL(ctx context.Context, c cache.Cache[interface{}], key string, value interface{},
+	ttl time.Duration,
+) error {
+	if ttl > 0 {
+		return c.SetWithTTL(ctx, key, value, ttl)
+	}
+
+	return c.Set(ctx, key, value)
+}
+
+// set stores the value, expiring after ttl if it's not zero.
+func (s *stateStore) set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if s.fallback() {
+		if err := setWithTTL(ctx, s.mirror, key, value, ttl); err != nil {
+			return err
+		}
+	}
+
+	err := setWithTTL(ctx, s.backend, key, value, ttl)
+	if !s.failed(ctx, err) {
+		return err
+	}
+
+	if !s.fallback() {
+		return errStoreUnavailable(err)
+	}
+
+	return nil
+}
+
+// get returns the value of the key.
+func (s *stateStore) get(ctx context.Context, key string) (interface{}, error) {
+	value, err := s.backend.Get(ctx, key)
+
+	failed, resynced := s.check(ctx, err)
+	if resynced {
+		// The key was read before being written back to the backend.
+		return s.backend.Get(ctx, key)
+	}
+
+	if !failed {
+		return value, err
+	}
+
+	if !s.fallback() {
+		return nil, errStoreUnavailable(err)
+	}
+
+	return s.mirror.Get(ctx, key)
+}
+
+// getAll returns the values of the keys matching.
+func (s *stateStore) getAll(ctx context.Context, matcher cache.Matcher) (map[string]interface{}, error) {
+	values, err := s.backend.GetAll(ctx, matcher)
+
+	failed, resynced := s.check(ctx, err)
+	if resynced {
+		return s.backend.GetAll(ctx, matcher)
+	}
+
+	if !failed {
+		return values, err
+	}
+
+	if !s.fallback() {
+		return nil, errStoreUnavailable(err)
+	}
+
+	return s.mirror.GetAll(ctx, matcher)
+}
+
+// delete deletes the key.
+func (s *stateStore) delete(ctx context.Context, key string) error {
+	if s.fallback() {
+		if err := s.mirror.Delete(ctx, key); err != nil && !errors.Is(err, cache.ErrNotFound) {
+			return err
+		}
+	}
+
+	err := s.backend.Delete(ctx, key)
+	if !s.failed(ctx, err) {
+		return err
+	}
+
+	if !s.fallback() {
+		return errStoreUnavailable(err)
+	}
+
+	return nil
+}
+
+// stateStoreStatus is the health of the state store.
+type stateStoreStatus struct {
+	// Status is available, degraded when the mirror is used while the backend
+	// is unavailable, or unavailable.
+	Status      string     `json:"status"`
+	Policy      string     `json:"policy"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// status probes the backend and returns the health of the state store.
+func (s *stateStore) status(ctx context.Context) stateStoreStatus {
+	_, err := s.backend.Get(ctx, stateStoreProbeKey)
+	s.failed(ctx, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := stateStoreStatus{
+		Status:      stateStoreAvailable,
+		Policy:      StoreUnavailablePolicy,
+		LastError:   s.lastError,
+		LastErrorAt: s.lastErrorAt,
+	}
+
+	if !s.available {
+		status.Status = stateStoreUnavailable
+		if s.fallback() {
+			status.Status = stateStoreDegraded
+		}
+	}
+
+	return status
+}
+
+// GetStateStoreStatus handles the state store health request. It responds
+// with a 503 when the state store is unavailable and not falling back.
+func GetStateStoreStatus(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	status := getStateStore(cache).status(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if status.Status == stateStoreUnavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Log(logger.LevelError, nil, err, "writing json payload to response")
+	}
+}
@@ -18,8 +18,13 @@ package portforward
 
 import (
 	"context"
-	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
 	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
@@ -27,6 +32,9 @@ import (
 
 const storeKeyPrefix = "PORT_FORWARD_"
 
+// stoppedReapInterval is how often the stopped port forwards are reaped.
+var stoppedReapInterval = time.Minute
+
 // portforwardKeyGenerator generates a unique key
 // based on the cluster name, id,service name, and pod name.
 func portforwardKeyGenerator(p portForward) string {
@@ -45,16 +53,83 @@ func portforwardKeyGenerator(p portForward) string {
 	return key
 }
 
-// portforwardstore stores a port forward in the cache.
+// portforwardstore stores a port forward in the cache, logging failures.
 func portforwardstore(cache cache.Cache[interface{}], p portForward) {
-	key := portforwardKeyGenerator(p)
-
-	err := cache.Set(context.Background(), key, p)
-	if err != nil {
+	if err := storePortForward(cache, p); err != nil {
 		logger.Log(logger.LevelError, nil, err, "storing portforward")
 	}
 }
 
+// loadShared sets the fields of the port forward which are kept in the state
+// shared by all its copies, being changed by other goroutines than the
+// supervisor of its tunnel, so whichever copy is stored or read has them.
+func (p *portForward) loadShared() {
+	// The name is the one the port forward was last renamed to.
+	if p.name != nil {
+		if name := p.name.Load(); name != nil {
+			p.Name = *name
+		}
+	}
+
+	// The pod monitor is disabled as last set, the supervisor's copy not
+	// seeing the requests disabling or enabling it.
+	if p.monitorDisabled != nil {
+		p.MonitorDisabled = p.monitorDisabled.Load()
+	}
+
+	if p.probeResult != nil {
+		if result := p.probeResult.Load(); result != nil {
+			p.ProbeResult = result
+		}
+	}
+
+	if p.traffic != nil {
+		p.StreamLimitHits = int(p.traffic.streamLimitHits.Load())
+		p.IdleConnectionsReaped = int(p.traffic.idleConnectionsReaped.Load())
+
+		if lastStreamError := p.traffic.lastStreamError.Load(); lastStreamError != nil {
+			p.LastStreamError = *lastStreamError
+		}
+	}
+}
+
+// storePortForward stores a port forward in the cache.
+// Stopped port forwards with an EntryTTLSeconds are stored with that TTL
+// so they expire from the cache on their own. Status changes are published
+// to the subscribers of the port forward events.
+func storePortForward(cache cache.Cache[interface{}], p portForward) error {
+	// Port forwards stored without the name the user knows their cluster by
+	// are shown with the name it is stored under.
+	if p.ClusterName == "" {
+		p.ClusterName = p.Cluster
+	}
+
+	p.loadShared()
+
+	// The time it stopped is kept while the port forward stays stopped.
+	switch {
+	case p.Status != STOPPED:
+		p.StoppedAt = nil
+	case p.StoppedAt == nil:
+		now := time.Now()
+		p.StoppedAt = &now
+	}
+
+	var ttl time.Duration
+
+	if p.Status == STOPPED && p.EntryTTLSeconds > 0 {
+		ttl = time.Duration(p.EntryTTLSeconds) * time.Second
+	}
+
+	if err := getStateStore(cache).set(context.Background(), portforwardKeyGenerator(p), p, ttl); err != nil {
+		return err
+	}
+
+	getEventHub(cache).publish(p)
+
+	return nil
+}
+
 // stopOrDeletePortForward stops or deletes a port forward by its cluster and id.
 // It takes three parameters: cluster is the name of the cluster, id is the unique identifier of the port forward,
 // isStopRequest is a boolean value indicating whether to stop or delete the port forward.
@@ -70,53 +145,406 @@ func stopOrDeletePortForward(cache cache.Cache[interface{}], cluster string, id
 
 	if isStopRequest {
 		// close the channel to stop the portforward
-		portforward.closeChan <- struct{}{}
+		safeCloseChan(portforward.closeChan)
 		portforward.Status = STOPPED
-		portforwardstore(cache, portforward)
+		portforward.recordEvent(eventStopped, "stopped by request")
+
+		if err := storePortForward(cache, portforward); err != nil {
+			logger.Log(logger.LevelError, map[string]string{"cluster": cluster, "id": id},
+				err, "storing stopped portforward")
+
+			return err
+		}
 	} else {
-		err := cache.Delete(context.Background(), portforwardKeyGenerator(portforward))
+		err := getStateStore(cache).delete(context.Background(), portforwardKeyGenerator(portforward))
 		if err != nil {
 			logger.Log(logger.LevelError, map[string]string{"cluster": cluster, "id": id},
 				err, "deleting portforward")
 
 			return err
 		}
+
+		getEventHub(cache).forget(portforward)
+	}
+
+	return nil
+}
+
+// reapStoppedPortForwards deletes the port forwards of all the clusters which
+// have been stopped for ttl or longer, returning how many were.
+func reapStoppedPortForwards(cache cache.Cache[interface{}], ttl time.Duration) (int, error) {
+	ctx := context.Background()
+
+	portForwards, err := getStateStore(cache).getAll(ctx, func(key string) bool {
+		return strings.HasPrefix(key, storeKeyPrefix)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+
+	for _, v := range portForwards {
+		pf, ok := v.(portForward)
+		if !ok || pf.Status != STOPPED || pf.StoppedAt == nil || time.Since(*pf.StoppedAt) < ttl {
+			continue
+		}
+
+		if err := getStateStore(cache).delete(ctx, portforwardKeyGenerator(pf)); err != nil {
+			return reaped, err
+		}
+
+		getEventHub(cache).forget(pf)
+
+		reaped++
+	}
+
+	return reaped, nil
+}
+
+// ReapStoppedPortForwards deletes the port forwards stopped for longer than
+// StoppedTTL, checking every stoppedReapInterval until ctx is done. It returns
+// right away if StoppedTTL is 0, the stopped port forwards being kept.
+func ReapStoppedPortForwards(ctx context.Context, cache cache.Cache[interface{}]) {
+	if StoppedTTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(stoppedReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reaped, err := reapStoppedPortForwards(cache, StoppedTTL)
+			if err != nil {
+				logger.Log(logger.LevelError, nil, err, "reaping stopped portforwards")
+			}
+
+			if reaped > 0 {
+				logger.Log(logger.LevelInfo, map[string]string{"count": strconv.Itoa(reaped)}, nil,
+					"reaped stopped portforwards")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// shutdownError is the error of the port forwards stopped as the backend shut down.
+const shutdownError = "stopped as the backend shut down"
+
+// Shutdown stops all the port forwards, of all the clusters, e.g. when the
+// backend is terminated, so their connections to the API servers are closed
+// rather than abandoned. It waits until ctx is done for their tunnels to be
+// closed, then marks the port forwards not stopped yet as stopped. The error
+// of ctx is returned if some tunnels weren't closed by then. The state store
+// of the cache is released afterwards, the cache being discarded with the backend.
+func Shutdown(ctx context.Context, cache cache.Cache[interface{}]) error {
+	defer releaseStateStore(cache)
+
+	isPortForward := func(key string) bool {
+		return strings.HasPrefix(key, storeKeyPrefix)
+	}
+
+	portForwards, err := getStateStore(cache).getAll(context.Background(), isPortForward)
+	if err != nil {
+		return err
+	}
+
+	exited := []chan struct{}{}
+
+	for _, v := range portForwards {
+		pf, ok := v.(portForward)
+		if !ok || pf.Status == STOPPED {
+			continue
+		}
+
+		safeCloseChan(pf.closeChan)
+
+		// The port forwards from other backends have no tunnel here.
+		if pf.exited != nil {
+			exited = append(exited, pf.exited)
+		}
+	}
+
+	waitErr := waitExited(ctx, exited)
+
+	// The tunnels closed stored their port forwards as stopped.
+	portForwards, err = getStateStore(cache).getAll(context.Background(), isPortForward)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range portForwards {
+		pf, ok := v.(portForward)
+		if !ok || pf.Status == STOPPED {
+			continue
+		}
+
+		pf.Status = STOPPED
+		pf.Error = shutdownError
+		pf.recordEvent(eventStopped, shutdownError)
+
+		if err := storePortForward(cache, pf); err != nil {
+			logger.Log(logger.LevelError, pf.logParams(map[string]string{"cluster": pf.Cluster}),
+				err, "storing stopped portforward")
+		}
+	}
+
+	return waitErr
+}
+
+// waitExited waits for all the channels to be closed, until ctx is done.
+func waitExited(ctx context.Context, exited []chan struct{}) error {
+	for _, ch := range exited {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	return nil
 }
 
-// getPortForwardList returns a list of port forwards by its cluster name.
-func getPortForwardList(cache cache.Cache[interface{}], cluster string) []portForward {
-	portforwards, err := cache.GetAll(context.Background(), func(key string) bool {
+// drainPortForwards stops the running port forwards accepting local
+// connections and waits up to timeout for their open connections to be
+// closed, draining them all at once. The port forwards from other backends
+// can't be drained.
+func drainPortForwards(portForwards []portForward, timeout time.Duration) {
+	var wg sync.WaitGroup
+
+	for _, pf := range portForwards {
+		if pf.Status != RUNNING || len(pf.listeners) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			pf.recordEvent(eventDraining, "draining connections for up to "+timeout.String())
+
+			if !pf.listeners.drain(timeout) {
+				logger.Log(logger.LevelWarn, map[string]string{"cluster": pf.Cluster, "id": pf.ID}, nil,
+					"connections still open after draining portforward, closing them")
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// getPortForwardList returns a list of port forwards by its cluster name,
+// sorted by start time then id. The keys of the port forwards of a cluster are
+// prefixed with its name, as are the ones of the clusters whose name starts
+// with it, e.g. the ones of the other users of the cluster, stored under the
+// cluster name with their user id appended, so the cluster is matched exactly.
+func getPortForwardList(cache cache.Cache[interface{}], cluster string) ([]portForward, error) {
+	portforwards, err := getStateStore(cache).getAll(context.Background(), func(key string) bool {
 		return strings.HasPrefix(key, storeKeyPrefix+cluster)
 	})
 	if err != nil {
 		logger.Log(logger.LevelError, map[string]string{"cluster": cluster},
 			err, "getting portforward list")
 
-		return nil
+		return nil, err
 	}
 
 	portForwards := []portForward{}
+
+	for _, v := range portforwards {
+		pf, ok := v.(portForward)
+		if !ok || pf.Cluster != cluster {
+			continue
+		}
+
+		pf.loadShared()
+
+		portForwards = append(portForwards, pf)
+	}
+
+	sortPortForwards(portForwards)
+
+	return portForwards, nil
+}
+
+// sortPortForwards sorts the port forwards by start time then id.
+func sortPortForwards(portForwards []portForward) {
+	sort.Slice(portForwards, func(i, j int) bool {
+		a, b := portForwards[i], portForwards[j]
+		if !a.StartedAt.Equal(b.StartedAt) {
+			return a.StartedAt.Before(b.StartedAt)
+		}
+
+		return a.ID < b.ID
+	})
+}
+
+// getUserPortForwards returns the port forwards of the user, of all the
+// clusters, by the clean name of their cluster and sorted as by
+// getPortForwardList. The port forwards of a user are stored under their
+// cluster name with the user id appended, so the ones of a cluster whose name
+// ends with the id of another user are told apart by their clean name.
+func getUserPortForwards(cache cache.Cache[interface{}], userID string) (map[string][]portForward, error) {
+	portforwards, err := getStateStore(cache).getAll(context.Background(), func(key string) bool {
+		return strings.HasPrefix(key, storeKeyPrefix) && strings.Contains(key, userID)
+	})
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "getting user portforward list")
+
+		return nil, err
+	}
+
+	clusters := map[string][]portForward{}
+
 	for _, v := range portforwards {
-		portForwards = append(portForwards, v.(portForward))
+		pf := v.(portForward)
+		if pf.Cluster != pf.ClusterName+userID {
+			continue
+		}
+
+		pf.loadShared()
+
+		clusters[pf.ClusterName] = append(clusters[pf.ClusterName], pf)
+	}
+
+	for _, portForwards := range clusters {
+		sortPortForwards(portForwards)
+	}
+
+	return clusters, nil
+}
+
+// portForwardFilter selects port forwards by their namespace, pod and
+// status. The empty fields match any port forward.
+type portForwardFilter struct {
+	namespace string
+	pod       string
+	// statuses are the statuses matched, any of them.
+	statuses []string
+}
+
+// newPortForwardFilter returns the filter of the namespace, pod and status
+// query params, the latter being a comma separated list of statuses.
+func newPortForwardFilter(query url.Values) portForwardFilter {
+	f := portForwardFilter{namespace: query.Get("namespace"), pod: query.Get("pod")}
+
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			f.statuses = append(f.statuses, status)
+		}
+	}
+
+	return f
+}
+
+// matches tells whether the port forward matches all the fields of the filter.
+func (f portForwardFilter) matches(p portForward) bool {
+	if f.namespace != "" && p.Namespace != f.namespace {
+		return false
+	}
+
+	if f.pod != "" && p.Pod != f.pod {
+		return false
+	}
+
+	return len(f.statuses) == 0 || slices.Contains(f.statuses, p.Status)
+}
+
+// filter returns the port forwards matching the filter, in their order.
+func (f portForwardFilter) filter(portForwards []portForward) []portForward {
+	filtered := []portForward{}
+
+	for _, p := range portForwards {
+		if f.matches(p) {
+			filtered = append(filtered, p)
+		}
+	}
+
+	return filtered
+}
+
+// portForwardTarget is a target of the port forwards of a cluster, and the
+// number of running port forwards to it.
+type portForwardTarget struct {
+	Namespace  string `json:"namespace"`
+	Pod        string `json:"pod"`
+	TargetPort string `json:"targetPort"`
+	Count      int    `json:"count"`
+}
+
+// getPortForwardTargets returns the distinct targets of the running port
+// forwards of the cluster, sorted by namespace, pod and target port.
+func getPortForwardTargets(cache cache.Cache[interface{}], cluster string) ([]portForwardTarget, error) {
+	portForwards, err := getPortForwardList(cache, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []portForwardTarget{}
+	indexes := map[portForwardTarget]int{}
+
+	for _, pf := range portForwards {
+		if pf.Status != RUNNING {
+			continue
+		}
+
+		for _, pair := range pf.portPairs() {
+			key := portForwardTarget{Namespace: pf.Namespace, Pod: pf.Pod, TargetPort: pair.TargetPort}
+
+			i, ok := indexes[key]
+			if !ok {
+				i = len(targets)
+				indexes[key] = i
+				targets = append(targets, key)
+			}
+
+			targets[i].Count++
+		}
 	}
 
-	return portForwards
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+
+		return a.TargetPort < b.TargetPort
+	})
+
+	return targets, nil
 }
 
 // getPortForwardByID returns a port forward by its cluster name and id.
 func getPortForwardByID(cache cache.Cache[interface{}], cluster string, id string) (portForward, error) {
-	cacheValue, err := cache.Get(context.Background(), storeKeyPrefix+cluster+id)
+	cacheValue, err := getStateStore(cache).get(context.Background(), storeKeyPrefix+cluster+id)
+	if errorCode(err) == ErrCodeStoreUnavailable {
+		return portForward{}, err
+	}
+
 	if err != nil {
-		return portForward{}, fmt.Errorf("failed to get portforward from cache: %v", err)
+		return portForward{}, newError(ErrCodeNotFound, err, "failed to get portforward from cache")
 	}
 
 	pf, ok := cacheValue.(portForward)
 	if !ok {
-		return portForward{}, fmt.Errorf("failed to convert cache value to portforward")
+		return portForward{}, newError(ErrCodeInternal, nil, "failed to convert cache value to portforward")
 	}
 
+	// The key of the port forward of another cluster, whose name starts with
+	// the one of the cluster, may be the one of the cluster and another id.
+	if pf.Cluster != cluster {
+		return portForward{}, newError(ErrCodeNotFound, nil, "failed to get portforward from cache")
+	}
+
+	pf.loadShared()
+
 	return pf, nil
 }
@@ -0,0 +1,689 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+)
+
+var (
+	// errStoppedBeforeReady is returned when the port forward is stopped
+	// while waiting for a tunnel to become ready.
+	errStoppedBeforeReady = newError(ErrCodeStopped, nil, "portforward stopped before becoming ready")
+	// errReadinessTimeout is returned when a tunnel doesn't become ready in time.
+	errReadinessTimeout = newError(ErrCodeReadinessTimeout, nil, "timeout waiting for portforward to become ready")
+	// errNotRetargetable is returned when retargeting a port forward which
+	// only targets the pod it was started with.
+	errNotRetargetable = errors.New("portforward has no pod selection to retarget with")
+)
+
+// startRetryBackoff is the delay before retrying to start a tunnel which
+// failed to become ready, doubled for the next retries.
+var startRetryBackoff = 500 * time.Millisecond
+
+// idleStoppedError is the error of a port forward stopped by its idle timeout.
+const idleStoppedError = "closed due to inactivity"
+
+// requestStoppedError is the error of a port forward stopped or deleted by a
+// request, without a more specific reason.
+const requestStoppedError = "stopped by request"
+
+// serverClosedError is the error of a port forward whose tunnel was ended by
+// the API server, rather than stopped by Headlamp.
+const serverClosedError = "port forward closed by the API server"
+
+// idleCheckInterval is how often the activity of a port forward with an idle timeout is checked.
+var idleCheckInterval = time.Second
+
+// keepAliveDialTimeout bounds connecting to a tunnel to keep it alive.
+const keepAliveDialTimeout = 5 * time.Second
+
+// livenessFailureThreshold is the number of failed liveness checks in a row
+// after which a port forward is stopped.
+const livenessFailureThreshold = 3
+
+// livenessCheckInterval is how often the local ports of a port forward with
+// a liveness check are checked to accept connections.
+var livenessCheckInterval = 10 * time.Second
+
+// tunnel is a port forwarder to a single pod, listening on forwarderAddress.
+// Local connections reach it through the localListener of the port forward,
+// which allows moving the port forward to another pod with a new tunnel.
+type tunnel struct {
+	pod       string
+	nodeName  string
+	forwarder *portforward.PortForwarder
+	stopChan  chan struct{}
+	readyChan chan struct{}
+	out       *syncBuffer
+	errOut    *syncBuffer
+	// dialErr is the error dialing the apiserver, if it failed. It is set
+	// before the tunnel is done.
+	dialErr error
+	// protocol is the streaming protocol negotiated with the apiserver, set
+	// once dialed, before the tunnel is ready. It is set on the port forward
+	// by the goroutine waiting for the tunnel, as the dial happens on another.
+	protocol string
+	// job is the Job of the pod, if any.
+	job string
+	// ports are the port pairs forwarded, with the numbers of the ports of the pod.
+	ports []PortPair
+	// done receives the result of ForwardPorts once it returns.
+	done chan error
+	// warning is the stderr of the port forwarder once ready, when it only
+	// holds warnings.
+	warning string
+	// hostNetwork tells the pod is on the host network.
+	hostNetwork bool
+}
+
+// podLoss reports that the pod of a tunnel isn't running anymore.
+type podLoss struct {
+	pod    string
+	reason string
+}
+
+// openTunnel creates a tunnel to the target ports, numbers, of the port pairs
+// of the pod for the port forward. It is started with run.
+func openTunnel(rConf *rest.Config, pfDetails *portForward,
+	pod, nodeName string, ports []PortPair, dialHeaders map[string]string,
+) (*tunnel, error) {
+	// The port forwarder picks free ports, the requested ones are
+	// listened on by the local listeners.
+	portMappings := make([]string, 0, len(ports))
+	for _, pair := range ports {
+		portMappings = append(portMappings, "0:"+pair.TargetPort)
+	}
+
+	t := &tunnel{pod: pod, nodeName: nodeName, ports: ports, done: make(chan error, 1)}
+
+	forwarder, stopChan, readyChan, out, errOut, err := initPortForwarder(
+		rConf, pfDetails.Namespace, pod, portMappings, dialHeaders,
+		func(protocol string, err error) {
+			if err != nil {
+				t.dialErr = err
+
+				return
+			}
+
+			t.protocol = protocol
+		},
+		func(err error) { recordStreamError(pfDetails, pod, err) },
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	t.forwarder = forwarder
+	t.stopChan = stopChan
+	t.readyChan = readyChan
+	t.out = out
+	t.errOut = errOut
+
+	return t, nil
+}
+
+// run starts forwarding in a goroutine.
+func (t *tunnel) run() {
+	go func() {
+		t.done <- t.forwarder.ForwardPorts()
+	}()
+}
+
+// output returns the lines the port forwarder wrote to its output.
+func (t *tunnel) output() []string {
+	if t.out == nil {
+		return nil
+	}
+
+	var lines []string
+
+	for _, line := range strings.Split(t.out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// addresses returns the addresses the tunnel listens on for each of its port
+// pairs, in their order, once it is ready.
+func (t *tunnel) addresses() ([]string, error) {
+	ports, err := t.forwarder.GetPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(ports))
+	for _, port := range ports {
+		addresses = append(addresses, net.JoinHostPort(forwarderAddress, strconv.Itoa(int(port.Local))))
+	}
+
+	return addresses, nil
+}
+
+// keepAlive nudges the tunnel listening on address by opening a connection
+// to it and closing it right away, which opens streams on its SPDY connection.
+// The local listeners are bypassed, so it doesn't count as activity.
+func keepAlive(address string) error {
+	conn, err := net.DialTimeout("tcp", address, keepAliveDialTimeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// address returns the address the tunnel listens on for its first port pair.
+func (t *tunnel) address() (string, error) {
+	addresses, err := t.addresses()
+	if err != nil {
+		return "", err
+	}
+
+	return addresses[0], nil
+}
+
+// klogWarningLine matches the lines logged at the warning level by klog, e.g.
+// by client-go, like "W1016 10:00:00.000000   1234 file.go:12] message".
+var klogWarningLine = regexp.MustCompile(`^W\d{4} `)
+
+// stderrWarning returns the lines of the stderr of a port forwarder, joined,
+// if all of them are warnings, the ones prefixed with "Warning:" or logged at
+// the warning level by klog, and false if any of them is an error.
+func stderrWarning(stderr string) (string, bool) {
+	var warnings []string
+
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(strings.ToLower(line), "warning:") && !klogWarningLine.MatchString(line) {
+			return "", false
+		}
+
+		warnings = append(warnings, line)
+	}
+
+	return strings.Join(warnings, "; "), true
+}
+
+// joinWarnings returns the warnings which aren't empty, joined.
+func joinWarnings(warnings ...string) string {
+	nonEmpty := make([]string, 0, len(warnings))
+
+	for _, warning := range warnings {
+		if warning != "" {
+			nonEmpty = append(nonEmpty, warning)
+		}
+	}
+
+	return strings.Join(nonEmpty, "; ")
+}
+
+// waitTunnelReady waits for the tunnel to be ready, failing if it stops,
+// reports errors, isn't ready within timeout, or if closeChan is closed first.
+// The warnings reported don't fail it and are kept as its warning.
+func waitTunnelReady(t *tunnel, closeChan chan struct{}, timeout time.Duration) error {
+	select {
+	case <-t.readyChan:
+		stderr := t.errOut.String()
+		if stderr == "" {
+			return nil
+		}
+
+		warning, ok := stderrWarning(stderr)
+		if !ok {
+			return newError(ErrCodeInternal, nil, "portforward failed to start, stderr: %s", stderr)
+		}
+
+		t.warning = warning
+
+		return nil
+	case err := <-t.done:
+		if err == nil {
+			return errStoppedBeforeReady
+		}
+
+		// The port forwarder doesn't keep the type of the dial errors.
+		if errors.Is(t.dialErr, errUpgradeTimeout) {
+			return newError(ErrCodeUnreachable, t.dialErr, "API server didn't upgrade the portforward connection")
+		}
+
+		if t.dialErr != nil && isConnectionError(t.dialErr) {
+			return newError(ErrCodeUnreachable, t.dialErr, "API server unreachable")
+		}
+
+		return err
+	case <-time.After(timeout):
+		return errReadinessTimeout
+	case <-closeChan:
+		return errStoppedBeforeReady
+	}
+}
+
+// startReadyTunnel starts a tunnel with start and waits for it to be ready.
+// A tunnel failing to, e.g. because its SPDY upgrade failed during an
+// apiserver rollout, is retried with exponential backoff up to MaxStartRetries
+// times. Timeouts, port forwards stopped meanwhile and failures with a code,
+// like forbidden ones, aren't retried, except for an unreachable API server.
+// The retries are logged with logParams.
+func startReadyTunnel(start func() (*tunnel, error), closeChan chan struct{}, timeout time.Duration,
+	logParams map[string]string,
+) (*tunnel, error) {
+	backoff := startRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		t, err := start()
+		if err != nil {
+			return nil, err
+		}
+
+		err = waitTunnelReady(t, closeChan, timeout)
+		if err == nil {
+			return t, nil
+		}
+
+		safeCloseChan(t.stopChan)
+
+		if code := errorCode(err); code != ErrCodeInternal && code != ErrCodeUnreachable {
+			return nil, err
+		}
+
+		if attempt > MaxStartRetries {
+			if attempt > 1 {
+				err = newError(errorCode(err), err, "portforward failed after %d attempts", attempt)
+			}
+
+			return nil, err
+		}
+
+		retryParams := map[string]string{}
+		maps.Copy(retryParams, logParams)
+		retryParams["pod"] = t.pod
+		retryParams["attempt"] = strconv.Itoa(attempt)
+
+		logger.Log(logger.LevelWarn, retryParams, err, "portforward failed to start, retrying")
+
+		select {
+		case <-time.After(backoff):
+		case <-closeChan:
+			return nil, errStoppedBeforeReady
+		}
+
+		backoff *= 2
+	}
+}
+
+// retargetPortForward opens a ready tunnel to another pod of the port forward's
+// pod selection, for when its pod went away. Named target ports are resolved
+// again, as the pod may number them differently, and the pod is checked for the
+// host network again.
+func retargetPortForward(clientset kubernetes.Interface, rConf *rest.Config, cache cache.Cache[interface{}],
+	pfDetails *portForward, dialHeaders map[string]string,
+) (*tunnel, error) {
+	sel := pfDetails.podSelection()
+	if sel.isEmpty() {
+		return nil, errNotRetargetable
+	}
+
+	pfDetails.Status = RECONNECTING
+	pfDetails.recordEvent(eventReconnecting, "")
+	portforwardstore(cache, *pfDetails)
+
+	ctx, cancel := contextUntil(context.Background(), pfDetails.closeChan)
+	defer cancel()
+
+	// Auto reconnecting waits for a pod of the workload to be ready, as
+	// during a rollout or when a StatefulSet pod is recreated.
+	timeout := apiRequestTimeout
+	if pfDetails.AutoReconnect {
+		timeout = pfDetails.readinessTimeout()
+	}
+
+	ctx, cancelTimeout := context.WithTimeout(ctx, timeout)
+	defer cancelTimeout()
+
+	resolve := resolvePod
+	if pfDetails.AutoReconnect {
+		resolve = resolveReadyPod
+	}
+
+	pod, err := resolve(ctx, clientset, pfDetails.Namespace, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	hostNetwork, err := checkHostNetwork(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := pfDetails.portPairs()
+	for i, pair := range ports {
+		if pair.TargetPortName == "" {
+			continue
+		}
+
+		if ports[i].TargetPort, err = resolveTargetPort(pod, pfDetails.Container, pair.TargetPortName); err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := openTunnel(rConf, pfDetails, pod.Name, pod.Spec.NodeName, ports, dialHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	t.job = podJob(pod)
+	t.hostNetwork = hostNetwork
+	t.run()
+
+	if err := waitTunnelReady(t, pfDetails.closeChan, pfDetails.readinessTimeout()); err != nil {
+		safeCloseChan(t.stopChan)
+
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// superviseTunnel runs for the lifetime of the port forward. It stops the
+// current tunnel once the port forward's closeChan is closed, and when the
+// tunnel or its pod is lost, it retargets the port forward to another pod
+// if it has a pod selection or otherwise stops it. It also stops the port
+// forward once idle, if it has an idle timeout, or once its local ports stop
+// accepting connections, if it has a liveness check.
+func superviseTunnel(
+	clientset kubernetes.Interface,
+	cache cache.Cache[interface{}],
+	pfDetails *portForward,
+	t *tunnel,
+	listeners localListeners,
+	retarget func() (*tunnel, error),
+) {
+	defer safeCloseChan(pfDetails.exited)
+	defer listeners.Close()
+
+	recordRunning(pfDetails, 1)
+	defer recordRunning(pfDetails, -1)
+
+	closeChan := pfDetails.closeChan
+
+	var idleCheck <-chan time.Time
+
+	if pfDetails.IdleTimeoutSeconds > 0 {
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+
+		idleCheck = ticker.C
+	}
+
+	var (
+		livenessCheck    <-chan time.Time
+		livenessFailures int
+	)
+
+	if pfDetails.LivenessCheck {
+		ticker := time.NewTicker(livenessCheckInterval)
+		defer ticker.Stop()
+
+		livenessCheck = ticker.C
+	}
+
+	var keepAliveCheck <-chan time.Time
+
+	if pfDetails.KeepAliveSeconds > 0 {
+		ticker := time.NewTicker(time.Duration(pfDetails.KeepAliveSeconds) * time.Second)
+		defer ticker.Stop()
+
+		keepAliveCheck = ticker.C
+	}
+
+	// stopReason is why the port forward was stopped, once it is.
+	var stopReason string
+
+	for {
+		logParams := pfDetails.logParams(map[string]string{
+			"pod": t.pod, "port": pfDetails.Port, "targetPort": pfDetails.TargetPort,
+		})
+
+		select {
+		case <-closeChan:
+			// The port forward is being stopped, the tunnel result follows.
+			safeCloseChan(t.stopChan)
+
+			closeChan = nil
+			stopReason = requestStoppedError
+
+			// A paused port forward keeps its status once its tunnel is stopped,
+			// and one stopped with an error, e.g. when reconciled, keeps it.
+			pf, err := getPortForwardByID(cache, pfDetails.Cluster, pfDetails.ID)
+			if err == nil && pf.Status == PAUSED {
+				pfDetails.Status = PAUSED
+				pfDetails.recordEvent(eventPaused, "")
+			} else if err == nil && pf.Error != "" {
+				stopReason = pf.Error
+			}
+
+		case <-idleCheck:
+			if closeChan == nil || !pfDetails.isIdle() {
+				continue
+			}
+
+			logger.Log(logger.LevelInfo, logParams, nil, "stopping idle port-forward")
+
+			pfDetails.Status = STOPPED
+			pfDetails.Error = idleStoppedError
+
+			pfDetails.recordEvent(eventStopped, idleStoppedError)
+			portforwardstore(cache, *pfDetails)
+			safeCloseChan(pfDetails.closeChan)
+
+			idleCheck = nil
+
+		case <-livenessCheck:
+			if closeChan == nil {
+				continue
+			}
+
+			err := listeners.checkServing()
+			if err == nil {
+				livenessFailures = 0
+
+				continue
+			}
+
+			livenessFailures++
+
+			logger.Log(logger.LevelWarn, logParams, err, "checking the local port is serving")
+			pfDetails.recordEvent(eventFailed, "liveness check: "+err.Error())
+
+			if livenessFailures < livenessFailureThreshold {
+				continue
+			}
+
+			pfDetails.Status = STOPPED
+			pfDetails.Error = fmt.Sprintf("local port stopped accepting connections: %v", err)
+
+			pfDetails.recordEvent(eventStopped, pfDetails.Error)
+			portforwardstore(cache, *pfDetails)
+			safeCloseChan(pfDetails.closeChan)
+
+			livenessCheck = nil
+
+		case <-keepAliveCheck:
+			if closeChan == nil {
+				continue
+			}
+
+			address, err := t.address()
+			if err == nil {
+				err = keepAlive(address)
+			}
+
+			if err != nil {
+				logger.Log(logger.LevelWarn, logParams, err, "keeping the port forward alive")
+				recordError(pfDetails, errorStageKeepAlive, err)
+				pfDetails.recordEvent(eventFailed, "keep-alive: "+err.Error())
+			}
+
+		case req := <-pfDetails.rebinds:
+			if closeChan == nil {
+				req.result <- newError(ErrCodeStopped, nil, "portforward %s is stopping", pfDetails.ID)
+
+				continue
+			}
+
+			req.result <- rebindLocalPort(cache, pfDetails, listeners, req)
+
+		case loss := <-pfDetails.podLost:
+			// Losses reported for the pod of a previous tunnel are stale.
+			if loss.pod != t.pod {
+				continue
+			}
+
+			if t = retargetOrStop(clientset, cache, pfDetails, t, listeners, retarget, loss.reason); t == nil {
+				recordPodMonitorStop(pfDetails)
+
+				return
+			}
+
+		case err := <-t.done:
+			if err == nil {
+				logger.Log(logger.LevelInfo, logParams, nil, "ForwardPorts() exited.")
+
+				// ForwardPorts returns on its own when the API server ends the tunnel.
+				if closeChan != nil {
+					stopReason = serverClosedError
+				}
+
+				if pfDetails.Status == RUNNING {
+					pfDetails.Status = STOPPED
+					if pfDetails.Error == "" {
+						pfDetails.Error = stopReason
+					}
+
+					pfDetails.recordEvent(eventStopped, pfDetails.Error)
+					portforwardstore(cache, *pfDetails)
+				}
+
+				return
+			}
+
+			logger.Log(logger.LevelError, logParams, err, "ForwardPorts() failed")
+
+			if closeChan == nil {
+				pfDetails.Status = STOPPED
+				pfDetails.Error = err.Error()
+
+				pfDetails.recordEvent(eventStopped, err.Error())
+				portforwardstore(cache, *pfDetails)
+
+				return
+			}
+
+			if t = retargetOrStop(clientset, cache, pfDetails, t, listeners, retarget, err.Error()); t == nil {
+				return
+			}
+		}
+	}
+}
+
+// retargetOrStop handles losing the tunnel: it returns a new tunnel to another
+// pod now used by the listeners, or nil once the port forward is stopped.
+func retargetOrStop(
+	clientset kubernetes.Interface,
+	cache cache.Cache[interface{}],
+	pfDetails *portForward,
+	t *tunnel,
+	listeners localListeners,
+	retarget func() (*tunnel, error),
+	reason string,
+) *tunnel {
+	logParams := pfDetails.logParams(map[string]string{"pod": t.pod, "namespace": pfDetails.Namespace})
+
+	newTunnel, err := retarget()
+	if err == nil {
+		addresses, err := newTunnel.addresses()
+		if err == nil {
+			safeCloseChan(t.stopChan)
+			listeners.setTargets(addresses)
+
+			pfDetails.Status = RUNNING
+			pfDetails.Pod = newTunnel.pod
+			pfDetails.NodeName = newTunnel.nodeName
+			pfDetails.setPortPairs(newTunnel.ports)
+			pfDetails.Job = newTunnel.job
+			// The pods retargeted to are picked ready, if any.
+			pfDetails.HostNetwork = newTunnel.hostNetwork
+			pfDetails.Warning = newTunnel.warning
+
+			if newTunnel.hostNetwork {
+				pfDetails.Warning = joinWarnings(hostNetworkWarning, newTunnel.warning)
+			}
+
+			pfDetails.Protocol = newTunnel.protocol
+			pfDetails.ForwarderOutput = newTunnel.output()
+			pfDetails.markReconnected()
+
+			pfDetails.recordEvent(eventRetargeted, "pod "+newTunnel.pod+": "+reason)
+			portforwardstore(cache, *pfDetails)
+			logger.Log(logger.LevelInfo, logParams, errors.New(reason),
+				"port forward retargeted to pod "+newTunnel.pod)
+
+			go monitorPodAndManagePortForward(clientset, pfDetails, newTunnel)
+
+			return newTunnel
+		}
+
+		safeCloseChan(newTunnel.stopChan)
+	}
+
+	if !errors.Is(err, errNotRetargetable) {
+		reason = fmt.Sprintf("%s, retargeting failed: %v", reason, err)
+	}
+
+	logger.Log(logger.LevelError, logParams, errors.New(reason), "stopping port-forward")
+
+	pfDetails.Status = STOPPED
+	pfDetails.Error = reason
+
+	pfDetails.recordEvent(eventStopped, reason)
+	portforwardstore(cache, *pfDetails)
+	safeCloseChan(pfDetails.closeChan)
+	safeCloseChan(t.stopChan)
+
+	return nil
+}
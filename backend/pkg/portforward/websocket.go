@@ -0,0 +1,170 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package portforward
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/cache"
+	"github.com/kubernetes-sigs/headlamp/backend/pkg/logger"
+)
+
+// wsCloseTimeout bounds writing the close message of a WebSocket connection.
+const wsCloseTimeout = time.Second
+
+// wsUpgrader upgrades the port forward WebSocket connections. As for the
+// multiplexer, the frontend may be served from another origin, e.g. in development.
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// wsConn is a WebSocket connection read and written as a stream of bytes,
+// the data being sent in binary messages.
+type wsConn struct {
+	*websocket.Conn
+	// reader is the reader of the message being read, if any.
+	reader io.Reader
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, reader, err := c.NextReader()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			}
+
+			if err != nil {
+				return 0, err
+			}
+
+			c.reader = reader
+		}
+
+		n, err := c.reader.Read(b)
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+
+			// An empty message has nothing to read, the next one is read instead.
+			if n == 0 {
+				continue
+			}
+
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.SetWriteDeadline(t)
+}
+
+// Close sends a close message, so the client knows the connection was closed
+// on purpose, then closes the connection.
+func (c *wsConn) Close() error {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = c.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsCloseTimeout))
+
+	return c.Conn.Close()
+}
+
+// webSocketListener returns the listener of the WebSocket port forward the
+// connections to the target port are bridged to, the first one if empty.
+func webSocketListener(pf portForward, targetPort string) (*localListener, error) {
+	if !pf.WebSocket {
+		return nil, newError(ErrCodeInvalidRequest, nil, "portforward %s isn't bridged to WebSockets", pf.ID)
+	}
+
+	// The listeners of the port forwards from another backend aren't known.
+	if pf.Status != RUNNING || len(pf.listeners) == 0 {
+		return nil, newError(ErrCodeStopped, nil, "portforward %s is not running", pf.ID)
+	}
+
+	if targetPort == "" {
+		return pf.listeners[0], nil
+	}
+
+	for i, pair := range pf.portPairs() {
+		if pair.TargetPort == targetPort || pair.TargetPortName == targetPort {
+			return pf.listeners[i], nil
+		}
+	}
+
+	return nil, newError(ErrCodeNotFound, nil, "portforward %s doesn't forward target port %s", pf.ID, targetPort)
+}
+
+// PortForwardWebSocket handles the WebSocket connections to a port forward
+// started with webSocket, given by its cluster and id. Each WebSocket
+// connection is proxied to the pod as a connection to a local port is, to the
+// targetPort query param or the first target port, the data being exchanged
+// in binary messages.
+func PortForwardWebSocket(cache cache.Cache[interface{}], w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cluster := query.Get("cluster")
+	id := query.Get("id")
+
+	if cluster == "" || id == "" {
+		logger.Log(logger.LevelError, nil, errors.New("cluster and id are required"), "bridging portforward websocket")
+		http.Error(w, "cluster and id are required", http.StatusBadRequest)
+
+		return
+	}
+
+	var l *localListener
+
+	pf, err := getPortForwardByID(cache, userClusterName(r, cluster), id)
+	if err == nil {
+		l, err = webSocketListener(pf, query.Get("targetPort"))
+	}
+
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"cluster": cluster, "id": id}, err, "bridging portforward websocket")
+		http.Error(w, err.Error(), errorStatus(err))
+
+		return
+	}
+
+	// The upgrader writes the error response, if it fails.
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"id": id}, err, "upgrading portforward websocket")
+
+		return
+	}
+
+	l.serve(&wsConn{Conn: conn})
+}
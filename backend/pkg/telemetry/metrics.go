@@ -3,6 +3,8 @@ package telemetry
 import (
 	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
@@ -121,6 +123,15 @@ func initApplicationMetrics(meter metric.Meter, metrics *Metrics) error {
 	return nil
 }
 
+// MetricsHandler returns the handler of the Prometheus metrics endpoint.
+// With exemplars, the metrics are served in the OpenMetrics format, which
+// carries the exemplars, to the scrapers asking for it, and in the Prometheus
+// text format without exemplars to the others.
+func MetricsHandler(exemplars bool) http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: exemplars}))
+}
+
 // RequestCounterMiddleware creates HTTP middleware that tracks request metrics.
 func (m *Metrics) RequestCounterMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
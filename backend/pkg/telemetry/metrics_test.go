@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	cfg "github.com/kubernetes-sigs/headlamp/backend/pkg/config"
 	tel "github.com/kubernetes-sigs/headlamp/backend/pkg/telemetry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,6 +15,7 @@ import (
 	"go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -314,3 +316,50 @@ func sumDataPoints(data metricdata.Aggregation) int64 {
 
 	return 0
 }
+
+func TestMetricsHandlerExemplars(t *testing.T) {
+	serviceVersion := "1.0.0"
+	trueVal := true
+	falseVal := false
+
+	telemetry, err := tel.NewTelemetry(cfg.Config{
+		ServiceName:      "test-service",
+		ServiceVersion:   &serviceVersion,
+		TracingEnabled:   &falseVal,
+		MetricsEnabled:   &trueVal,
+		MetricsExemplars: &trueVal,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = telemetry.Shutdown(context.Background())
+	})
+
+	counter, err := otel.Meter("test").Int64Counter("test.exemplar.count")
+	require.NoError(t, err)
+
+	traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	})
+	counter.Add(trace.ContextWithSpanContext(context.Background(), spanContext), 1)
+
+	scrape := func(accept string) string {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", accept)
+
+		recorder := httptest.NewRecorder()
+		tel.MetricsHandler(true).ServeHTTP(recorder, req)
+
+		return recorder.Body.String()
+	}
+
+	openMetrics := scrape("application/openmetrics-text; version=1.0.0")
+	assert.Contains(t, openMetrics, `trace_id="`+traceID.String()+`"`)
+
+	text := scrape("text/plain")
+	assert.Contains(t, text, "test_exemplar_count_total")
+	assert.NotContains(t, text, "trace_id")
+}
@@ -17,6 +17,7 @@ import (
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
@@ -58,7 +59,7 @@ func NewTelemetry(cfg cfg.Config) (*Telemetry, error) {
 
 	// Initialize metrics provider if metrics are enabled
 	if *cfg.MetricsEnabled {
-		if err := setupMetrics(t, res); err != nil {
+		if err := setupMetrics(t, res, cfg); err != nil {
 			// Clean up trace provider if metrics setup fails
 			if t.tracerProvider != nil {
 				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -101,15 +102,22 @@ func createResource(cfg cfg.Config) (*resource.Resource, error) {
 // setupMetrics initializes and configures the metrics components.
 // It creates a Prometheus exporter and sets up a meter provider,
 // registering it with the global OpenTelemetry instance.
-func setupMetrics(t *Telemetry, res *resource.Resource) error {
+// Exemplars are only recorded if enabled, for measurements made in sampled spans.
+func setupMetrics(t *Telemetry, res *resource.Resource, cfg cfg.Config) error {
 	promExporter, err := prometheus.New()
 	if err != nil {
 		return fmt.Errorf("failed to initialize Prometheus exporter: %w", err)
 	}
 
+	exemplarFilter := exemplar.AlwaysOffFilter
+	if cfg.MetricsExemplars != nil && *cfg.MetricsExemplars {
+		exemplarFilter = exemplar.TraceBasedFilter
+	}
+
 	mp := metric.NewMeterProvider(
 		metric.WithReader(promExporter),
 		metric.WithResource(res),
+		metric.WithExemplarFilter(exemplarFilter),
 	)
 	if mp == nil {
 		return fmt.Errorf("meter provider initialization returned nil")
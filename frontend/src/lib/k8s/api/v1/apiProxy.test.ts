@@ -965,7 +965,7 @@ describe('apiProxy', () => {
             }
           } else if (url.includes('portforward') && url.includes('list')) {
             return Promise.resolve(
-              new Response(JSON.stringify(mockListResponse), {
+              new Response(JSON.stringify({ items: mockListResponse, total: 1 }), {
                 status: 200,
                 headers: { 'content-type': 'application/json' },
               })
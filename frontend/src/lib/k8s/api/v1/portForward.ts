@@ -33,6 +33,15 @@ export interface PortForward {
   targetPort: string;
   status?: string;
   error?: string;
+  startedAt?: string;
+}
+
+/**
+ * The response of the port forward list endpoint.
+ */
+export interface PortForwardList {
+  items: PortForward[];
+  total: number;
 }
 
 export interface PortForwardRequest {
@@ -159,7 +168,7 @@ export async function stopOrDeletePortForward(
  *
  * @param cluster - The cluster to list the port forwards.
  *
- * @returns the list of port forwards for the cluster.
+ * @returns the list of port forwards for the cluster, sorted by start time then id.
  */
 export async function listPortForward(cluster: string): Promise<PortForward[]> {
   const kubeconfig = await findKubeconfigByClusterName(cluster);
@@ -172,5 +181,7 @@ export async function listPortForward(cluster: string): Promise<PortForward[]> {
 
   return fetch(`${getAppUrl()}portforward/list?cluster=${cluster}`, {
     headers: new Headers(headers),
-  }).then(response => response.json());
+  })
+    .then(response => response.json())
+    .then((data: PortForwardList | PortForward[]) => (Array.isArray(data) ? data : data.items));
 }